@@ -0,0 +1,29 @@
+package testdb
+
+import "database/sql/driver"
+
+// Makes every call of the query, from db.Query() or db.Exec(), fail with driver.ErrBadConn. database/sql retries each call on other connections before giving up and handing driver.ErrBadConn back, use StubBadConnOnce() to have the retry succeed.
+func StubBadConn(q string) {
+	mustStub(d.conn.stubBoth(q, query{err: driver.ErrBadConn}))
+}
+
+// Makes the next call of the query, from db.Query() or db.Exec(), fail with driver.ErrBadConn, as if the connection had gone stale. database/sql discards the connection and retries on another one, where the query runs as stubbed, so code using the pool gets its result after one transparent retry. Call SetNewConnPerOpen(true) as well to have the retry run on a distinct connection.
+func StubBadConnOnce(q string) {
+	d.conn.mu.Lock()
+	d.conn.badConns[d.conn.hash(q)] = true
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) badConn(query string) error {
+	hash := c.hash(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.badConns[hash] {
+		return nil
+	}
+	delete(c.badConns, hash)
+
+	return driver.ErrBadConn
+}
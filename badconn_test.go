@@ -0,0 +1,154 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStubQueryErrorBadConnRetries(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	query := "select name from users"
+	StubQueryError(query, driver.ErrBadConn)
+
+	_, err := db.Query(query)
+
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected driver.ErrBadConn once retries are exhausted, got %v", err)
+	}
+
+	// database/sql tries twice on pooled connections, then once more on a new one
+	if QueryCallCount(query) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", QueryCallCount(query))
+	}
+}
+
+func TestBadConnRetriedOnNewConn(t *testing.T) {
+	defer Reset()
+
+	SetNewConnPerOpen(true)
+
+	var opened []*sessionConn
+	db := sql.OpenDB(connectorFunc(func() (driver.Conn, error) {
+		c, err := d.Open("")
+		opened = append(opened, c.(*sessionConn))
+		return c, err
+	}))
+	defer db.Close()
+
+	query := "select name from users"
+	StubQuerySequence(query,
+		QueryResult{Err: driver.ErrBadConn},
+		QueryResult{Rows: RowsFromCSVString([]string{"name"}, "tim")},
+	)
+
+	var name string
+	if err := db.QueryRow(query).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "tim" {
+		t.Fatal("query should succeed once retried on a new connection")
+	}
+
+	if len(opened) != 2 || !opened[0].closed || opened[1].closed {
+		t.Fatal("the bad connection should be closed and replaced by a new one")
+	}
+}
+
+type connectorFunc func() (driver.Conn, error)
+
+func (f connectorFunc) Connect(context.Context) (driver.Conn, error) {
+	return f()
+}
+
+func (f connectorFunc) Driver() driver.Driver {
+	return d
+}
+
+func TestOpenCount(t *testing.T) {
+	defer Reset()
+
+	SetNewConnPerOpen(true)
+	On("select pg_sleep(0.01)").Delay(10 * time.Millisecond).Return(RowsFromCSVString([]string{"pg_sleep"}, ""))
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rows, err := db.Query("select pg_sleep(0.01)")
+			if err == nil {
+				rows.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := OpenCount(); n < 2 || n > 3 {
+		t.Fatalf("expected between 2 and 3 connections to be opened, got %d", n)
+	}
+
+	ResetOpenCount()
+	if OpenCount() != 0 {
+		t.Fatal("ResetOpenCount should clear the count")
+	}
+}
+
+func TestStubBadConnOnce(t *testing.T) {
+	defer Reset()
+
+	SetNewConnPerOpen(true)
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	query := "select name from users"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+	StubExec("delete from users", NewRowsAffectedResult(1))
+	StubBadConnOnce(query)
+	StubBadConnOnce("delete from users")
+
+	var name string
+	if err := db.QueryRow(query).Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("the query should succeed after a retry, got %q %v", name, err)
+	}
+
+	if QueryCallCount(query) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", QueryCallCount(query))
+	}
+
+	if _, err := db.Exec("delete from users"); err != nil {
+		t.Fatalf("the exec should succeed after a retry, got %v", err)
+	}
+
+	if _, err := db.Query(query); err != nil {
+		t.Fatal("only the first call should fail")
+	}
+}
+
+func TestStubBadConn(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	query := "update users set name = ?"
+	StubBadConn(query)
+
+	if _, err := db.Exec(query, "tim"); !errors.Is(err, driver.ErrBadConn) {
+		t.Fatalf("expected driver.ErrBadConn, got %v", err)
+	}
+}
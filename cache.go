@@ -0,0 +1,41 @@
+package testdb
+
+import "database/sql/driver"
+
+// When set to true, rows returned by the query func are kept and every later call with the same query and arguments gets a fresh copy of them, instead of calling the func again. Only rows built by this package, such as RowsFromCSVString() or NewRows(), are cached, errors never are.
+func EnableResultCaching(flag bool) {
+	d.conn.mu.Lock()
+	d.conn.resultCaching = flag
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) resultKey(query string, args []driver.NamedValue) string {
+	return c.hash(query) + argsKey(values(args))
+}
+
+func (c *conn) cachedResult(key string) (driver.Rows, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.resultCaching {
+		return nil, false
+	}
+
+	r, ok := c.resultCache[key]
+	return r, ok
+}
+
+func (c *conn) cacheResult(key string, r driver.Rows) {
+	switch r.(type) {
+	case *rows, *generatedRows:
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.resultCaching {
+		c.resultCache[key] = cloneRows(r)
+	}
+}
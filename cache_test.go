@@ -0,0 +1,86 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestEnableResultCaching(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	parsed := 0
+	SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		parsed++
+		if args[0] == "missing" {
+			return nil, errors.New("not found")
+		}
+		return RowsFromCSVString([]string{"name"}, args[0].(string)), nil
+	})
+
+	EnableResultCaching(true)
+
+	for i := 0; i < 3; i++ {
+		var name string
+		if err := db.QueryRow("select name from users where name = ?", "tim").Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "tim" {
+			t.Fatalf("expected tim, got %s", name)
+		}
+	}
+
+	if parsed != 1 {
+		t.Fatalf("repeated queries should reuse the cached rows, the CSV was parsed %d times", parsed)
+	}
+
+	var name string
+	db.QueryRow("SELECT name FROM users WHERE name = ?", "joe").Scan(&name)
+	if parsed != 2 || name != "joe" {
+		t.Fatal("different arguments should not share a cached result")
+	}
+
+	db.Query("select name from users where name = ?", "missing")
+	db.Query("select name from users where name = ?", "missing")
+	if parsed != 4 {
+		t.Fatal("errors should not be cached")
+	}
+
+	EnableResultCaching(false)
+	db.QueryRow("select name from users where name = ?", "tim").Scan(&name)
+	if parsed != 5 {
+		t.Fatal("the query func should be called once caching is disabled")
+	}
+}
+
+func TestEnableResultCachingArgTypes(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		kind := "string"
+		if _, ok := args[0].(int64); ok {
+			kind = "int"
+		}
+		return RowsFromCSVString([]string{"kind"}, kind), nil
+	})
+
+	EnableResultCaching(true)
+
+	for _, tc := range []struct {
+		arg      interface{}
+		expected string
+	}{{1, "int"}, {"1", "string"}, {1, "int"}} {
+		var kind string
+		if err := db.QueryRow("select kind from values where v = ?", tc.arg).Scan(&kind); err != nil {
+			t.Fatal(err)
+		}
+		if kind != tc.expected {
+			t.Fatalf("%#v should get its own cached result, got %s", tc.arg, kind)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A single query or exec call received by the driver, along with the arguments bound to it. Prepared is set when the call was made on a prepared statement rather than directly on the connection.
+type Call struct {
+	Kind     CallKind
+	Query    string
+	Args     []Arg
+	Prepared bool
+}
+
+type CallKind int
+
+const (
+	CallQuery CallKind = iota
+	CallExec
+)
+
+// An argument bound to a query. Ordinal is the 1-based position of the argument, Name is only set for named parameters such as sql.Named().
+type Arg struct {
+	Ordinal int
+	Name    string
+	Value   driver.Value
+}
+
+func (a Arg) String() string {
+	if a.Name != "" {
+		return fmt.Sprintf("@%s=%s", a.Name, formatArg(a.Value))
+	}
+	return fmt.Sprintf("$%d=%s", a.Ordinal, formatArg(a.Value))
+}
+
+// Sets the function used to render argument values in logs and assertion failures. Pass nil to go back to the default, which quotes []byte values as strings cut off after 32 bytes and renders times as RFC3339.
+func SetArgFormatter(f func(v driver.Value) string) {
+	d.conn.mu.Lock()
+	d.conn.argFormatter = f
+	d.conn.mu.Unlock()
+}
+
+func formatArg(v driver.Value) string {
+	d.conn.mu.Lock()
+	f := d.conn.argFormatter
+	d.conn.mu.Unlock()
+
+	if f != nil {
+		return f(v)
+	}
+	return defaultArgFormat(v)
+}
+
+const maxFormattedBytes = 32
+
+func defaultArgFormat(v driver.Value) string {
+	switch val := v.(type) {
+	case []byte:
+		if len(val) > maxFormattedBytes {
+			return fmt.Sprintf("%q...", val[:maxFormattedBytes])
+		}
+		return fmt.Sprintf("%q", val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%#v", v)
+}
+
+func formatArgs(args []driver.Value) string {
+	formatted := make([]string, len(args))
+	for i, a := range args {
+		formatted[i] = formatArg(a)
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
+
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = a.String()
+	}
+	return c.Query + " [" + strings.Join(args, ", ") + "]"
+}
+
+func (c *conn) record(kind CallKind, query string, args []driver.NamedValue, prepared bool) Call {
+	call := Call{Kind: kind, Query: query, Args: make([]Arg, len(args)), Prepared: prepared}
+	for i, a := range args {
+		call.Args[i] = Arg{Ordinal: a.Ordinal, Name: a.Name, Value: a.Value}
+	}
+
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	c.mu.Unlock()
+
+	return call
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	return vals
+}
+
+// Returns every query and exec call received by the global driver.Conn, in the order they were made.
+func Calls() []Call {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return append([]Call(nil), d.conn.calls...)
+}
+
+// Returns the number of times Prepare() was called for the query, whether or not the statement was used afterwards.
+func PrepareCount(query string) int {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.prepareCounts[d.conn.hash(query)]
+}
+
+// Returns the number of times db.Query() was called for the query, either directly or on a prepared statement.
+func QueryCallCount(query string) int {
+	hash := d.conn.hash(query)
+
+	count := 0
+	for _, call := range Calls() {
+		if call.Kind == CallQuery && d.conn.hash(call.Query) == hash {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the argument values of the nth call of the query, counting from 1 across db.Query() and db.Exec() calls, or nil if the query wasn't called n times. Values are the ones the driver received, after database/sql converted them, so an int argument comes back as an int64.
+func ArgsOfCall(query string, n int) []driver.Value {
+	hash := d.conn.hash(query)
+
+	for _, call := range Calls() {
+		if d.conn.hash(call.Query) != hash {
+			continue
+		}
+
+		if n--; n == 0 {
+			return argValues(call.Args)
+		}
+	}
+	return nil
+}
+
+// Fails the test if the query was run at all, with db.Query() or db.Exec(), for code that should have skipped the database, on a cache hit for example. Queries are matched the same way as stubs.
+func AssertQueryNotCalled(t testing.TB, query string) {
+	t.Helper()
+
+	hash := d.conn.hash(query)
+
+	count := 0
+	for _, call := range Calls() {
+		if d.conn.hash(call.Query) == hash {
+			count++
+		}
+	}
+
+	if count > 0 {
+		t.Errorf("testdb: %s should not have been run, but was called %d times", query, count)
+	}
+}
+
+// Fails the test unless the query was called with exactly the supplied argument values. Queries are matched the same way as stubs, ignoring case and whitespace.
+func AssertCalledWith(t testing.TB, query string, args ...driver.Value) {
+	t.Helper()
+
+	hash := d.conn.hash(query)
+	var seen []string
+	for _, call := range Calls() {
+		if d.conn.hash(call.Query) != hash {
+			continue
+		}
+
+		if reflect.DeepEqual(argValues(call.Args), normalizeArgs(args)) {
+			return
+		}
+		seen = append(seen, call.String())
+	}
+
+	if len(seen) == 0 {
+		t.Errorf("testdb: %s was never called", query)
+		return
+	}
+
+	t.Errorf("testdb: %s was not called with %s, calls were:\n\t%s", query, formatArgs(normalizeArgs(args)), strings.Join(seen, "\n\t"))
+}
+
+func argValues(args []Arg) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, a := range args {
+		vals[i] = a.Value
+	}
+	return vals
+}
+
+// Converts expected argument values the same way database/sql converts bound arguments, so AssertCalledWith(t, q, 1) matches an int64 argument.
+func normalizeArgs(args []driver.Value) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, v := range args {
+		if cv, err := driver.DefaultParameterConverter.ConvertValue(v); err == nil {
+			v = cv
+		}
+		vals[i] = v
+	}
+	return vals
+}
+
+// Returns the raw text of every query or exec call that didn't match a stub, in the order they were made.
+func UnexpectedQueries() []string {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return append([]string(nil), d.conn.unexpected...)
+}
+
+// Fails the test if any query or exec call didn't match a stub. This is most useful along with SetMissingStubBehavior(MissingStubEmptyRows), where unstubbed queries don't return errors.
+func AssertNoUnexpectedQueries(t testing.TB) {
+	t.Helper()
+
+	if unexpected := UnexpectedQueries(); len(unexpected) > 0 {
+		t.Errorf("testdb: unexpected queries were run:\n\t%s", strings.Join(unexpected, "\n\t"))
+	}
+}
+
+// Makes t fail the moment a query or exec call that doesn't match a stub is run, with t.Fatalf() naming the query and the line that ran it, instead of only at the end of the test. Fatalf stops the test, so the queries must be run from the test's goroutine. Pass nil to turn it off.
+func SetFailFastOnUnexpected(t testing.TB) {
+	d.conn.mu.Lock()
+	d.conn.failFast = t
+	d.conn.mu.Unlock()
+}
+
+// Returns the file and line of the first caller outside database/sql and the non-test files of this package.
+func callSite() string {
+	pkg := reflect.TypeOf(conn{}).PkgPath() + "."
+
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		internal := strings.HasPrefix(f.Function, pkg) && !strings.HasSuffix(f.File, "_test.go")
+		if !internal && !strings.HasPrefix(f.Function, "database/sql.") && !strings.HasPrefix(f.Function, "runtime.") {
+			return fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// Returns the text of every query stubbed with StubQuery() and friends, or exec stubbed with StubExec() and friends, that hasn't matched a call yet, sorted. A stub for both db.Query() and db.Exec(), such as StubDriverError(), is listed once and counts as used once either of them matches.
+func UnusedStubs() []string {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	var unused []string
+	listed := make(map[*bool]bool)
+	for _, q := range d.conn.queries {
+		if q.forQuery() && !*q.used {
+			unused = append(unused, q.text)
+			listed[q.used] = true
+		}
+	}
+	for _, q := range d.conn.execs {
+		if q.forExec() && !*q.used && !listed[q.used] {
+			unused = append(unused, q.text)
+		}
+	}
+	sort.Strings(unused)
+
+	return unused
+}
+
+// Fails the test if any stubbed query or exec was never called, which usually means the stub is dead or the code under test took another path.
+func AssertAllStubsUsed(t testing.TB) {
+	t.Helper()
+
+	if unused := UnusedStubs(); len(unused) > 0 {
+		t.Errorf("testdb: stubs were never used:\n\t%s", strings.Join(unused, "\n\t"))
+	}
+}
+
+// Fails the test unless the query was run, and every call of it was made on a prepared statement, for code that must never build the query for a one-shot call.
+func AssertWasPrepared(t testing.TB, query string) {
+	t.Helper()
+
+	hash := d.conn.hash(query)
+	ran := false
+	for _, call := range Calls() {
+		if d.conn.hash(call.Query) != hash {
+			continue
+		}
+		if !call.Prepared {
+			t.Errorf("testdb: %s was run without being prepared", query)
+			return
+		}
+		ran = true
+	}
+
+	if !ran {
+		t.Errorf("testdb: %s was never run", query)
+	}
+}
+
+// When set to true, every query and exec call is recorded and answered with an empty result, no rows and no rows affected, without looking at the stubs at all. Statements can be prepared without being stubbed too. Running a code path in dry run mode and reading QueryLog() afterwards shows all the SQL it would run.
+func SetDryRun(flag bool) {
+	d.conn.mu.Lock()
+	d.conn.dryRun = flag
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) isDryRun() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.dryRun
+}
+
+// Same as QueryLog(), but only returns the text of exec calls.
+func ExecLog() []string {
+	var log []string
+	for _, call := range Calls() {
+		if call.Kind == CallExec {
+			log = append(log, call.Query)
+		}
+	}
+	return log
+}
+
+// Returns the text of every query and exec call received by the global driver.Conn, in the order they were made.
+func QueryLog() []string {
+	calls := Calls()
+
+	log := make([]string, len(calls))
+	for i, call := range calls {
+		log[i] = call.Query
+	}
+	return log
+}
+
+// Fails the test unless exactly the supplied queries were run, in that order. Queries are matched the same way as stubs.
+func AssertQueriesInOrder(t testing.TB, queries ...string) {
+	t.Helper()
+
+	log := QueryLog()
+	if len(log) == len(queries) {
+		matched := true
+		for i := range log {
+			if d.conn.hash(log[i]) != d.conn.hash(queries[i]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return
+		}
+	}
+
+	t.Errorf("testdb: queries did not run in the expected order\n%s", describeQueryOrder(queries, log))
+}
+
+// Fails the test unless the supplied queries were run in that order, other queries may have run before, after or in between them.
+func AssertQueriesSubsequence(t testing.TB, queries ...string) {
+	t.Helper()
+
+	log := QueryLog()
+	i := 0
+	for _, q := range log {
+		if i < len(queries) && d.conn.hash(q) == d.conn.hash(queries[i]) {
+			i++
+		}
+	}
+
+	if i < len(queries) {
+		t.Errorf("testdb: queries did not run in the expected order\n%s", describeQueryOrder(queries, log))
+	}
+}
+
+// Fails the test unless a ran before b, comparing the first time each of them was run. Both must have been run, and anything may have run around them.
+func AssertOrder(t testing.TB, a, b string) {
+	t.Helper()
+
+	log := QueryLog()
+	first := func(q string) int {
+		hash := d.conn.hash(q)
+		for i, logged := range log {
+			if d.conn.hash(logged) == hash {
+				return i
+			}
+		}
+		return -1
+	}
+
+	i, j := first(a), first(b)
+	switch {
+	case i < 0:
+		t.Errorf("testdb: %s was never run", a)
+	case j < 0:
+		t.Errorf("testdb: %s was never run", b)
+	case i > j:
+		t.Errorf("testdb: %s should have run before %s\n%s", a, b, describeQueryOrder([]string{a, b}, log))
+	}
+}
+
+func describeQueryOrder(expected, actual []string) string {
+	var b strings.Builder
+
+	b.WriteString("expected:\n")
+	for i, q := range expected {
+		fmt.Fprintf(&b, "\t%d. %s\n", i+1, q)
+	}
+
+	b.WriteString("actual:\n")
+	for i, q := range actual {
+		fmt.Fprintf(&b, "\t%d. %s\n", i+1, q)
+	}
+
+	return b.String()
+}
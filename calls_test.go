@@ -0,0 +1,693 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalls(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ? and age > @age"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	rows, err := db.Query(query, 5, sql.Named("age", 18))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	calls := Calls()
+	if len(calls) != 1 {
+		t.Fatalf("expected 1 call, got %d", len(calls))
+	}
+
+	args := calls[0].Args
+	if len(args) != 2 {
+		t.Fatalf("expected 2 args, got %d", len(args))
+	}
+
+	if args[0].Ordinal != 1 || args[0].Name != "" || args[0].Value != int64(5) {
+		t.Fatalf("unexpected positional arg: %v", args[0])
+	}
+
+	if args[1].Ordinal != 2 || args[1].Name != "age" || args[1].Value != int64(18) {
+		t.Fatalf("unexpected named arg: %v", args[1])
+	}
+
+	if calls[0].String() != query+" [$1=5, @age=18]" {
+		t.Fatalf("unexpected call description: %s", calls[0])
+	}
+
+	AssertCalledWith(t, query, 5, 18)
+}
+
+func TestAssertCalledWith(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "UPDATE users SET name = ? WHERE id = ?"
+	StubExec(query, NewResult(0, nil, 1, nil))
+
+	if _, err := db.Exec(query, "joe", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ft := &fakeTB{}
+	AssertCalledWith(ft, query, "tim", 1)
+	if !ft.failed {
+		t.Fatal("AssertCalledWith should fail for different args")
+	}
+
+	ft = &fakeTB{}
+	AssertCalledWith(ft, "select 1", "tim")
+	if !ft.failed {
+		t.Fatal("AssertCalledWith should fail for a query that was never called")
+	}
+
+	AssertCalledWith(t, query, "joe", 1)
+}
+
+// fakeTB records failures so the assertion helpers can be tested.
+type fakeTB struct {
+	testing.TB
+	failed bool
+	fatal  bool
+	msgs   []string
+
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+// Runs the registered cleanups the way testing does, last registered first.
+func (f *fakeTB) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.failed = true
+	f.msgs = append(f.msgs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...interface{}) {
+	f.failed = true
+	f.fatal = true
+	f.msgs = append(f.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestUnexpectedQueries(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMissingStubBehavior(MissingStubEmptyRows)
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	rows, err := db.Query("select name from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	AssertNoUnexpectedQueries(t)
+
+	rows, err = db.Query("select age from users")
+	if err != nil {
+		t.Fatal("unstubbed queries should not error with MissingStubEmptyRows")
+	}
+	if rows.Next() {
+		t.Fatal("unstubbed queries should return empty rows")
+	}
+	rows.Close()
+
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Fatal("unstubbed exec calls should not error with MissingStubEmptyRows")
+	}
+
+	unexpected := UnexpectedQueries()
+	if len(unexpected) != 2 || unexpected[0] != "select age from users" || unexpected[1] != "DELETE FROM users" {
+		t.Fatalf("unexpected queries not recorded: %v", unexpected)
+	}
+
+	ft := &fakeTB{}
+	AssertNoUnexpectedQueries(ft)
+	if !ft.failed || !strings.Contains(ft.msgs[0], "DELETE FROM users") {
+		t.Fatal("AssertNoUnexpectedQueries should report the raw query text")
+	}
+}
+
+func TestSetFailFastOnUnexpected(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	ft := &fakeTB{}
+	SetFailFastOnUnexpected(ft)
+
+	db.QueryRow("select name from users").Scan(new(string))
+	if ft.failed {
+		t.Fatalf("stubbed queries shouldn't fail the test, got %v", ft.msgs)
+	}
+
+	_, _, line, _ := runtime.Caller(0)
+	db.Exec("delete from users")
+
+	if !ft.fatal || len(ft.msgs) != 1 {
+		t.Fatalf("the unexpected query should fail the test at once, got %v", ft.msgs)
+	}
+	if !strings.Contains(ft.msgs[0], "delete from users") || !strings.Contains(ft.msgs[0], fmt.Sprintf("calls_test.go:%d", line+1)) {
+		t.Fatalf("the failure should name the query and where it was run, got %s", ft.msgs[0])
+	}
+
+	SetFailFastOnUnexpected(nil)
+	db.Exec("delete from admins")
+	if len(ft.msgs) != 1 {
+		t.Fatal("passing nil should turn fail fast off")
+	}
+}
+
+func TestPrepareCount(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < 3; i++ {
+		var name string
+		if err := stmt.QueryRow(i).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if PrepareCount(query) != 1 {
+		t.Fatalf("expected 1 prepare, got %d", PrepareCount(query))
+	}
+
+	if QueryCallCount(query) != 3 {
+		t.Fatalf("expected 3 queries, got %d", QueryCallCount(query))
+	}
+
+	if PrepareCount("select 1") != 0 {
+		t.Fatal("unprepared queries should have a count of 0")
+	}
+}
+
+func TestArgsOfCall(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ? and active = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	for i := 1; i <= 3; i++ {
+		var name string
+		if err := db.QueryRow(query, i, i%2 == 0).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		expected := []driver.Value{int64(i), i%2 == 0}
+		if args := ArgsOfCall("SELECT name FROM users WHERE id = ? AND active = ?", i); !reflect.DeepEqual(args, expected) {
+			t.Fatalf("call %d: expected %v, got %v", i, expected, args)
+		}
+	}
+
+	if ArgsOfCall(query, 0) != nil || ArgsOfCall(query, 4) != nil {
+		t.Fatal("out of range calls should return nil")
+	}
+}
+
+func TestAssertQueriesInOrder(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMissingStubBehavior(MissingStubEmptyRows)
+
+	db.Exec("insert into users (name) values (?)", "tim")
+	db.Query("select name from users")
+	db.Exec("delete from users")
+
+	AssertQueriesInOrder(t, "INSERT INTO users (name) VALUES (?)", "select name from users", "delete from users")
+	AssertQueriesSubsequence(t, "insert into users (name) values (?)", "delete from users")
+
+	ft := &fakeTB{}
+	AssertQueriesInOrder(ft, "select name from users", "insert into users (name) values (?)", "delete from users")
+	if !ft.failed {
+		t.Fatal("AssertQueriesInOrder should fail when queries ran out of order")
+	}
+
+	if !strings.Contains(ft.msgs[0], "expected:\n\t1. select name from users") || !strings.Contains(ft.msgs[0], "actual:\n\t1. insert into users (name) values (?)") {
+		t.Fatalf("failure should list expected and actual queries:\n%s", ft.msgs[0])
+	}
+
+	ft = &fakeTB{}
+	AssertQueriesInOrder(ft, "insert into users (name) values (?)", "delete from users")
+	if !ft.failed {
+		t.Fatal("AssertQueriesInOrder should fail when other queries ran")
+	}
+
+	ft = &fakeTB{}
+	AssertQueriesSubsequence(ft, "delete from users", "select name from users")
+	if !ft.failed {
+		t.Fatal("AssertQueriesSubsequence should fail when queries ran out of order")
+	}
+}
+
+func TestAssertOrder(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMissingStubBehavior(MissingStubEmptyRows)
+
+	db.Exec("begin work")
+	db.Exec("insert into users (name) values (?)", "tim")
+	db.Query("select name from users")
+	db.Exec("insert into users (name) values (?)", "joe")
+
+	AssertOrder(t, "INSERT INTO users (name) VALUES (?)", "select name from users")
+
+	ft := &fakeTB{}
+	AssertOrder(ft, "select name from users", "insert into users (name) values (?)")
+	if !ft.failed || !strings.Contains(ft.msgs[0], "should have run before") {
+		t.Fatalf("AssertOrder should fail when the first calls ran out of order, got %v", ft.msgs)
+	}
+
+	for _, queries := range [][2]string{
+		{"delete from users", "select name from users"},
+		{"select name from users", "delete from users"},
+	} {
+		ft = &fakeTB{}
+		AssertOrder(ft, queries[0], queries[1])
+		if !ft.failed || !strings.Contains(ft.msgs[0], "delete from users was never run") {
+			t.Fatalf("AssertOrder should fail when a query never ran, got %v", ft.msgs)
+		}
+	}
+}
+
+func TestSetDefaultColumns(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMissingStubBehavior(MissingStubEmptyRows)
+	SetDefaultColumns("select id, name from users", []string{"id", "name"})
+
+	res, err := db.Query("SELECT id, name FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	columns, err := res.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(columns, []string{"id", "name"}) {
+		t.Fatalf("expected the default columns, got %v", columns)
+	}
+
+	for res.Next() {
+		t.Fatal("no rows should be returned")
+	}
+
+	var id int64
+	var name string
+	if err := db.QueryRow("select id, name from users").Scan(&id, &name); err != sql.ErrNoRows {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	if len(UnexpectedQueries()) != 2 {
+		t.Fatal("queries answered with default columns should still be unexpected")
+	}
+}
+
+func TestArgFormatting(t *testing.T) {
+	defer Reset()
+
+	created := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	tests := []struct {
+		arg      Arg
+		expected string
+	}{
+		{Arg{Ordinal: 1, Value: int64(5)}, "$1=5"},
+		{Arg{Ordinal: 2, Value: "tim"}, `$2="tim"`},
+		{Arg{Ordinal: 3, Value: []byte("hello")}, `$3="hello"`},
+		{Arg{Ordinal: 4, Value: []byte(strings.Repeat("a", 40))}, `$4="` + strings.Repeat("a", 32) + `"...`},
+		{Arg{Name: "created", Value: created}, "@created=2012-10-01T01:00:01Z"},
+		{Arg{Ordinal: 5, Value: nil}, "$5=<nil>"},
+	}
+
+	for _, test := range tests {
+		if s := test.arg.String(); s != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, s)
+		}
+	}
+
+	SetArgFormatter(func(v driver.Value) string {
+		return fmt.Sprintf("<%v>", v)
+	})
+
+	if s := (Arg{Ordinal: 1, Value: int64(5)}).String(); s != "$1=<5>" {
+		t.Fatalf("the custom formatter should be used, got %s", s)
+	}
+
+	db, _ := sql.Open("testdb", "")
+	SetMissingStubBehavior(MissingStubEmptyRows)
+	db.Query("select name from users where id = ?", 1)
+
+	ft := &fakeTB{}
+	AssertCalledWith(ft, "select name from users where id = ?", 2)
+	if !strings.Contains(ft.msgs[0], "not called with [<2>]") || !strings.Contains(ft.msgs[0], "[$1=<1>]") {
+		t.Fatalf("assertion failures should use the formatter:\n%s", ft.msgs[0])
+	}
+}
+
+func TestAssertAllStubsUsed(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+	StubExec("delete from users", NewRowsAffectedResult(1))
+	StubQuery("select id from admins", RowsFromCSVString([]string{"id"}, "1"))
+
+	db.QueryRow("select id from users").Scan(new(int64))
+	db.Exec("delete from users")
+
+	if unused := UnusedStubs(); !reflect.DeepEqual(unused, []string{"select id from admins"}) {
+		t.Fatalf("expected only the admins query to be unused, got %v", unused)
+	}
+
+	ft := &fakeTB{}
+	AssertAllStubsUsed(ft)
+	if !ft.failed || !strings.Contains(ft.msgs[0], "select id from admins") {
+		t.Fatal("AssertAllStubsUsed should fail naming the unused stub")
+	}
+}
+
+func TestUnusedStubsQueryAndExec(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubBadConn("select 1")
+	StubDriverError("select id from locked", "40001", "deadlock")
+	On("select id from banned").ReturnError(errors.New("banned"))
+	StubDriverError("update locked set id = ?", "40001", "deadlock")
+
+	if unused := UnusedStubs(); !reflect.DeepEqual(unused, []string{"select 1", "select id from banned", "select id from locked", "update locked set id = ?"}) {
+		t.Fatalf("stubs for both Query and Exec should be listed once, got %v", unused)
+	}
+
+	db.Query("select 1")
+	db.Query("select id from locked")
+	db.Query("select id from banned")
+	db.Exec("update locked set id = ?", 1)
+
+	if unused := UnusedStubs(); len(unused) != 0 {
+		t.Fatalf("stubs for both Query and Exec should be used once either matches, got %v", unused)
+	}
+
+	ft := &fakeTB{}
+	AssertAllStubsUsed(ft)
+	if ft.failed {
+		t.Fatalf("AssertAllStubsUsed shouldn't fail, got %v", ft.msgs)
+	}
+}
+
+func TestUnusedStubsKinds(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	gen := func(args []driver.Value) ([][]driver.Value, []string, error) {
+		return [][]driver.Value{{int64(1)}}, []string{"id"}, nil
+	}
+	StubLazyQuery("select id from users", gen)
+	StubLazyQuery("select id from admins", gen)
+	StubNoRows("select id from guests")
+	StubDriverError("select id from locked", "40001", "deadlock")
+	StubExecFunc("update users set name = ?", func(args []driver.Value) (driver.Result, error) {
+		return NewRowsAffectedResult(1), nil
+	})
+	StubExecAutoIncrement("insert into users (name) values (?)", 1)
+
+	expected := []string{
+		"insert into users (name) values (?)",
+		"select id from admins",
+		"select id from guests",
+		"select id from locked",
+		"select id from users",
+		"update users set name = ?",
+	}
+	if unused := UnusedStubs(); !reflect.DeepEqual(unused, expected) {
+		t.Fatalf("expected %v to be unused, got %v", expected, unused)
+	}
+
+	db.QueryRow("select id from users").Scan(new(int64))
+	db.Exec("update users set name = ?", "tim")
+	db.Exec("insert into users (name) values (?)", "tim")
+	db.Query("select id from locked")
+
+	expected = []string{"select id from admins", "select id from guests"}
+	if unused := UnusedStubs(); !reflect.DeepEqual(unused, expected) {
+		t.Fatalf("expected %v to be unused, got %v", expected, unused)
+	}
+}
+
+func TestBindStrict(t *testing.T) {
+	defer Reset()
+
+	ft := &fakeTB{}
+	db, _ := BindStrict(ft)
+
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+	StubQuery("select id from admins", RowsFromCSVString([]string{"id"}, "1"))
+	db.QueryRow("select id from users").Scan(new(int64))
+
+	ft.finish()
+	if !ft.failed {
+		t.Fatal("BindStrict should fail the test when a stub is unused")
+	}
+
+	ft = &fakeTB{}
+	db, _ = BindStrict(ft)
+
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+	db.QueryRow("select id from users").Scan(new(int64))
+
+	ft.finish()
+	if ft.failed {
+		t.Fatalf("BindStrict shouldn't fail when every stub is used, got %v", ft.msgs)
+	}
+}
+
+func TestAssertWasPrepared(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from users where name = ?"
+	StubQuery(query, RowsFromCSVString([]string{"id"}, "1"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	stmt.QueryRow("tim").Scan(new(int64))
+
+	AssertWasPrepared(t, query)
+
+	db.QueryRow(query, "tim").Scan(new(int64))
+
+	ft := &fakeTB{}
+	AssertWasPrepared(ft, query)
+	if !ft.failed {
+		t.Fatal("AssertWasPrepared should fail once the query is run directly")
+	}
+
+	ft = &fakeTB{}
+	AssertWasPrepared(ft, "select id from admins")
+	if !ft.failed {
+		t.Fatal("AssertWasPrepared should fail for a query that never ran")
+	}
+}
+
+func TestAssertQueryNotCalled(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	cache := map[int]string{}
+	lookup := func(id int) string {
+		if name, ok := cache[id]; ok {
+			return name
+		}
+
+		var name string
+		db.QueryRow(query, id).Scan(&name)
+		cache[id] = name
+		return name
+	}
+
+	cache[1] = "tim"
+	lookup(1)
+	AssertQueryNotCalled(t, query)
+
+	lookup(2)
+	lookup(3)
+
+	ft := &fakeTB{}
+	AssertQueryNotCalled(ft, query)
+	if !ft.failed || !strings.Contains(ft.msgs[0], "called 2 times") {
+		t.Fatalf("AssertQueryNotCalled should fail with the call count, got %v", ft.msgs)
+	}
+}
+
+func TestLastRowCount(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from reports"
+	StubQuery(query, RowsGenerated([]string{"id"}, 10, func(i int) []driver.Value {
+		return []driver.Value{int64(i)}
+	}))
+
+	if LastRowCount(query) != 0 {
+		t.Fatal("a query that hasn't run should count 0 rows")
+	}
+
+	res, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for res.Next() {
+	}
+	res.Close()
+
+	if n := LastRowCount(query); n != 10 {
+		t.Fatalf("expected 10 rows, got %d", n)
+	}
+
+	res, err = db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Next()
+	res.Next()
+	res.Close()
+
+	if n := LastRowCount(query); n != 2 {
+		t.Fatalf("only the rows read by the latest query should be counted, got %d", n)
+	}
+}
+
+func TestSetDryRun(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetDryRun(true)
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.QueryRow("select id from users").Scan(new(int64)); err != sql.ErrNoRows {
+		t.Fatalf("stubs should be ignored in a dry run, got %v", err)
+	}
+
+	res, err := tx.Exec("insert into users (name) values (?)", "tim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Fatal("exec calls should affect no rows in a dry run")
+	}
+
+	stmt, err := tx.Prepare("update users set active = ? where id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(true, 1); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"select id from users", "insert into users (name) values (?)", "update users set active = ? where id = ?"}
+	if log := QueryLog(); !reflect.DeepEqual(log, expected) {
+		t.Fatalf("expected %q, got %q", expected, log)
+	}
+
+	if log := ExecLog(); !reflect.DeepEqual(log, expected[1:]) {
+		t.Fatalf("expected %q, got %q", expected[1:], log)
+	}
+
+	if len(UnexpectedQueries()) != 0 {
+		t.Fatal("a dry run shouldn't report unexpected queries")
+	}
+}
+
+func TestLastColumns(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select * from users"
+	if LastColumns(query) != nil {
+		t.Fatal("a query that hasn't run should have no columns")
+	}
+
+	StubQuery(query, RowsFromCSVString([]string{"id", "name", "email"}, "1,tim,tim@example.com"))
+
+	var id int64
+	db.QueryRow(query).Scan(&id, new(string), new(string))
+
+	if columns := LastColumns(query); !reflect.DeepEqual(columns, []string{"id", "name", "email"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+}
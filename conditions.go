@@ -0,0 +1,24 @@
+package testdb
+
+import "fmt"
+
+// Stubs the query to return a result without any rows, so db.QueryRow(q).Scan() returns sql.ErrNoRows. The result has no columns either, which database/sql never checks when there is no row to scan.
+func StubNoRows(q string) {
+	StubQuery(q, RowsFromSlice(nil, nil))
+}
+
+// An error as a database driver would return it, with a vendor code such as a SQLSTATE or a MySQL error number alongside the message, so code that inspects the code with errors.As() can be tested without importing a real driver. Stub it with StubDriverError(), or pass it to StubQueryError() and StubExecError() like any other error, wrapped or not.
+type DriverError struct {
+	Code    string
+	Message string
+}
+
+func (e *DriverError) Error() string {
+	return fmt.Sprintf("testdb: %s (%s)", e.Message, e.Code)
+}
+
+// Stubs the query, from db.Query() or db.Exec(), to fail with a *DriverError holding the supplied code and message.
+func StubDriverError(q string, code string, message string) {
+	err := &DriverError{Code: code, Message: message}
+	mustStub(d.conn.stubBoth(q, query{err: err}))
+}
@@ -0,0 +1,70 @@
+package testdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStubNoRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ?"
+	StubNoRows(query)
+
+	var name string
+	if err := db.QueryRow(query, 1).Scan(&name); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+
+	rows, err := db.Query(query, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		t.Fatal("expected no rows")
+	}
+}
+
+func TestStubDriverError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "insert into users (email) values (?)"
+	StubDriverError(query, "23505", "duplicate key value violates unique constraint")
+
+	for _, err := range []error{
+		db.QueryRow(query, "tim@example.com").Scan(new(int64)),
+		func() error { _, err := db.Exec(query, "tim@example.com"); return err }(),
+	} {
+		var driverErr *DriverError
+		if !errors.As(err, &driverErr) || driverErr.Code != "23505" {
+			t.Fatalf("expected a driver error with code 23505, got %v", err)
+		}
+	}
+}
+
+func TestDriverErrorWrapped(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQueryError("select * from locked", &DriverError{Code: "55P03", Message: "lock not available"})
+	StubExecError("insert into orders (id) values (?)", fmt.Errorf("inserting order: %w", &DriverError{Code: "1062", Message: "Duplicate entry"}))
+
+	_, queryErr := db.Query("select * from locked")
+	_, execErr := db.Exec("insert into orders (id) values (?)", 1)
+
+	for code, err := range map[string]error{"55P03": queryErr, "1062": execErr} {
+		var driverErr *DriverError
+		if !errors.As(err, &driverErr) || driverErr.Code != code {
+			t.Fatalf("expected errors.As to find code %s, got %v", code, err)
+		}
+	}
+}
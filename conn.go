@@ -1,80 +1,277 @@
 package testdb
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
 )
 
 type conn struct {
+	mu sync.Mutex
+	*connState
+}
+
+// Everything a conn knows about, kept separately so Reset() can clear a conn that database/sql is still holding on to.
+type connState struct {
 	queries      map[string]query
-	queryFunc    func(query string, args []driver.Value) (driver.Rows, error)
+	execs        map[string]query
+	queryFunc    func(ctx context.Context, query string, args []driver.Value) (driver.Rows, error)
 	execFunc     func(query string, args []driver.Value) (driver.Result, error)
 	beginFunc    func() (driver.Tx, error)
 	commitFunc   func() error
 	rollbackFunc func() error
+	txDoneErr    error
+	txOptions    []driver.TxOptions
+
+	serializationFailures map[string]bool
+	badConns              map[string]bool
+	failNextCommit        bool
+	openTxs               int
+	readOnlyTxs           int
+	enforceReadOnlyTx     bool
+
+	argMatchers      map[string][]argMatcher
+	ctxMatchers      map[string][]ctxMatcher
+	argStubs         map[string]map[string]driver.Rows
+	verbStubs        map[string]query
+	verbArityStubs   map[verbArity]query
+	defaultCols      map[string][]string
+	prepareErrors    map[string]error
+	prepareDelay     time.Duration
+	maxQueryDuration time.Duration
+	numInputs        map[string]int
+
+	ignoredClauses       [][]string
+	caseSensitive        bool
+	matchInlinedLiterals bool
+	collapseInLists      bool
+	ignorePaging         bool
+	stripSchemas         bool
+	fingerprint          func(query string) (string, error)
+	rewriter             func(query string) string
+
+	forbidDuplicateStubs bool
+	missingStubBehavior  MissingStubBehavior
+	always               *query
+	errorAfterSequence   bool
+	execerDisabled       bool
+	enforceKind          bool
+	closed               bool
+	dryRun               bool
+	resultCaching        bool
+
+	directQueryCount   int
+	preparedQueryCount int
+	prepareCounts      map[string]int
+	stmtCloses         int
+	rowsCloses         int
+	connCloses         int
+	rowCounts          map[string]*int64
+	lastColumns        map[string][]string
+	calls              []Call
+	logger             io.Writer
+	observer           Observer
+	argFormatter       func(v driver.Value) string
+	unexpected         []string
+	failFast           testing.TB
+	copies             map[string][][]driver.Value
+	copyPending        map[string]int
+	resultCache        map[string]driver.Rows
+	seeds              map[string]*rows
+	durations          map[string]time.Duration
+	script             *scriptRun
 }
 
 func newConn() *conn {
-	return &conn{
-		queries: make(map[string]query),
+	return &conn{connState: newConnState()}
+}
+
+func newConnState() *connState {
+	return &connState{
+		queries:               make(map[string]query),
+		execs:                 make(map[string]query),
+		serializationFailures: make(map[string]bool),
+		badConns:              make(map[string]bool),
+		argMatchers:           make(map[string][]argMatcher),
+		ctxMatchers:           make(map[string][]ctxMatcher),
+		argStubs:              make(map[string]map[string]driver.Rows),
+		verbStubs:             make(map[string]query),
+		verbArityStubs:        make(map[verbArity]query),
+		seeds:                 make(map[string]*rows),
+		durations:             make(map[string]time.Duration),
+		defaultCols:           make(map[string][]string),
+		prepareErrors:         make(map[string]error),
+		prepareCounts:         make(map[string]int),
+		rowCounts:             make(map[string]*int64),
+		lastColumns:           make(map[string][]string),
+		numInputs:             make(map[string]int),
+		copies:                make(map[string][][]driver.Value),
+		copyPending:           make(map[string]int),
+		resultCache:           make(map[string]driver.Rows),
 	}
 }
 
-func (c *conn) Prepare(query string) (driver.Stmt, error) {
-	s := new(stmt)
+func (c *conn) reset() {
+	c.mu.Lock()
+	c.connState = newConnState()
+	c.mu.Unlock()
+}
 
-	if c.queryFunc != nil {
-		s.queryFunc = func(args []driver.Value) (driver.Rows, error) {
-			return c.queryFunc(query, args)
-		}
+func (c *conn) stub(q string, qu query) error {
+	return c.stubInto(c.queries, q, qu)
+}
+
+// Stubs are kept apart from the ones for queries, so a statement can be stubbed to return rows from Query() and fail from Exec().
+func (c *conn) stubExec(q string, qu query) error {
+	return c.stubInto(c.execs, q, qu)
+}
+
+// Stubs the query for both db.Query() and db.Exec(), as one stub that counts as used once either of them matches.
+func (c *conn) stubBoth(q string, qu query) error {
+	qu.used = new(bool)
+	if err := c.stubInto(c.queries, q, qu); err != nil {
+		return err
 	}
+	return c.stubInto(c.execs, q, qu)
+}
 
-	if c.execFunc != nil {
-		s.execFunc = func(args []driver.Value) (driver.Result, error) {
-			return c.execFunc(query, args)
-		}
+// Returns an error for a query that can't be stubbed, one without any SQL in it.
+func checkStubQuery(q string) error {
+	if isEmptyQuery(q) {
+		return errors.New("testdb: can't stub an empty query")
 	}
+	return nil
+}
 
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
-		if s.queryFunc == nil && q.rows != nil {
-			s.queryFunc = func(args []driver.Value) (driver.Rows, error) {
-				if q.rows != nil {
-					if rows, ok := q.rows.(*rows); ok {
-						return rows.clone(), nil
-					}
-					return q.rows, nil
-				}
-				return nil, q.err
-			}
-		}
+func (c *conn) stubInto(stubs map[string]query, q string, qu query) error {
+	if err := checkStubQuery(q); err != nil {
+		return err
+	}
 
-		if s.execFunc == nil && q.result != nil {
-			s.execFunc = func(args []driver.Value) (driver.Result, error) {
-				if q.result != nil {
-					return q.result, nil
-				}
-				return nil, q.err
-			}
-		}
+	hash := c.hash(q)
+	if _, ok := stubs[hash]; ok && c.forbidDuplicateStubs {
+		return errors.New("Query already stubbed: " + q)
+	}
+
+	qu.text = q
+	if qu.used == nil {
+		qu.used = new(bool)
+	}
+	if qu.columns == nil && qu.rows != nil {
+		qu.columns = qu.rows.Columns()
+	}
+	stubs[hash] = qu
+	return nil
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := sleepContext(ctx, c.prepareDelay); err != nil {
+		return nil, err
+	}
+
+	return c.prepare(query)
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) prepare(query string) (driver.Stmt, error) {
+	query = c.rewrite(query)
+	c.logf("prepare %s", query)
+	c.notify(func(o Observer) { o.OnPrepare(query) })
+
+	c.mu.Lock()
+	c.prepareCounts[c.hash(query)]++
+	c.mu.Unlock()
+
+	if isEmptyQuery(query) {
+		return nil, errors.New("testdb: can't prepare an empty query")
 	}
 
-	if s.queryFunc == nil && s.execFunc == nil {
-		return new(stmt), errors.New("Query not stubbed: " + query)
+	if err, ok := c.prepareErrors[c.hash(query)]; ok {
+		return nil, err
 	}
 
-	return s, nil
+	if !c.isStubbed(c.hash(query)) && !c.isVerbStubbed(query) && !isCopyFromStdin(query) && c.queryFunc == nil && c.execFunc == nil && c.script == nil && c.always == nil && !c.isSeeded(query) && !c.isDryRun() && c.missingStubBehavior == MissingStubError {
+		c.recordUnexpected(query)
+		return nil, c.notStubbed("Query not stubbed: ", query, c.queries)
+	}
+
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	c.countClose(&c.connCloses)
+	c.setClosed(true)
+	c.notify(func(o Observer) { o.OnClose() })
+	return nil
+}
+
+func (c *conn) setClosed(flag bool) {
+	c.mu.Lock()
+	c.closed = flag
+	c.mu.Unlock()
 }
 
-func (*conn) Close() error {
+// A connection handed out by Open when SetNewConnPerOpen(true) is set, it shares the stubs of the global conn but is closed on its own.
+type sessionConn struct {
+	*conn
+	closed bool
+}
+
+func (s *sessionConn) Close() error {
+	s.closed = true
+	s.countClose(&s.connCloses)
+	s.setClosed(true)
+	s.notify(func(o Observer) { o.OnClose() })
 	return nil
 }
 
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.txOptions = append(c.txOptions, opts)
+	c.mu.Unlock()
+
+	tx, err := c.Begin()
+	if t, ok := tx.(*Tx); ok && err == nil && opts.ReadOnly {
+		t.readOnly = true
+		c.mu.Lock()
+		c.readOnlyTxs++
+		c.mu.Unlock()
+	}
+
+	return tx, err
+}
+
 func (c *conn) Begin() (driver.Tx, error) {
-	if c.beginFunc != nil {
+	c.logf("begin")
+	c.notify(func(o Observer) { o.OnBegin() })
+
+	if _, ok, err := c.scriptStep(stepBegin, ""); err != nil {
+		return nil, err
+	} else if !ok && c.beginFunc != nil {
 		return c.beginFunc()
 	}
 
-	t := &Tx{}
+	t := &Tx{conn: c, doneErr: c.txDoneErr}
+	c.mu.Lock()
+	c.openTxs++
+	c.mu.Unlock()
+
 	if c.commitFunc != nil {
 		t.SetCommitFunc(c.commitFunc)
 	}
@@ -86,30 +283,401 @@ func (c *conn) Begin() (driver.Tx, error) {
 }
 
 func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return c.QueryContext(context.Background(), query, namedValues(args))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.mu.Lock()
+	c.directQueryCount++
+	c.mu.Unlock()
+
+	return c.query(ctx, c.rewrite(query), args, false)
+}
+
+func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	return c.ExecContext(context.Background(), query, namedValues(args))
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.execerDisabled {
+		return nil, driver.ErrSkip
+	}
+
+	return c.exec(ctx, c.rewrite(query), args, false)
+}
+
+// Applies the rewriter set with SetQueryRewriter(), prepared statements are rewritten once when they are prepared.
+func (c *conn) rewrite(query string) string {
+	if c.rewriter == nil {
+		return query
+	}
+	return c.rewriter(query)
+}
+
+func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue, prepared bool) (driver.Rows, error) {
+	c.logf("query %s", c.record(CallQuery, query, args, prepared))
+	c.notify(func(o Observer) { o.OnQuery(query, values(args)) })
+
+	start := now()
+	r, err := c.resolveQuery(ctx, query, args)
+	c.recordDuration(query, start)
+
+	// Rows that wait between rows stop waiting once the query's context is done
+	if rs, ok := r.(*rows); ok && rs.interval > 0 && rs.ctx == nil {
+		rs.ctx = ctx
+	}
+
+	if r != nil && err == nil {
+		r = c.countRows(query, r)
+	}
+
+	return r, err
+}
+
+func (c *conn) resolveQuery(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.isDryRun() {
+		return RowsFromSlice(nil, nil), nil
+	}
+
+	if err := c.checkNumInput(query, args); err != nil {
+		return nil, err
+	}
+
+	if err := c.serializationFailure(query); err != nil {
+		return nil, err
+	}
+
+	if err := c.badConn(query); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkKind(query, CallQuery); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkReadOnly(query); err != nil {
+		return nil, err
+	}
+
+	if step, ok, err := c.scriptStep(stepQuery, query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return cloneRows(step.rows), step.err
+	}
+
 	if c.queryFunc != nil {
-		return c.queryFunc(query, args)
+		key := c.resultKey(query, args)
+		if r, ok := c.cachedResult(key); ok {
+			return cloneRows(r), nil
+		}
+
+		// A query func returning nil rows and a nil error doesn't handle the query, so the stubs are checked instead
+		if rows, err := c.queryFunc(ctx, query, values(args)); rows != nil || err != nil {
+			if err == nil {
+				c.cacheResult(key, rows)
+			}
+			return rows, err
+		}
+	}
+
+	hash := c.hash(query)
+	if r, ok := c.argStubs[hash][argsKey(values(args))]; ok {
+		return cloneRows(r), nil
+	}
+
+	for _, m := range c.argMatchers[hash] {
+		if m.match(values(args)) {
+			return cloneRows(m.rows), nil
+		}
+	}
+
+	for _, m := range c.ctxMatchers[hash] {
+		if m.match(ctx) {
+			return cloneRows(m.rows), nil
+		}
 	}
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
-		if rows, ok := q.rows.(*rows); ok {
-			return rows.clone(), q.err
+
+	if q, ok := c.queries[hash]; ok && q.forQuery() && c.use(q) {
+		c.markUsed(q)
+
+		if err := c.checkTx(query, q); err != nil {
+			return nil, err
+		}
+
+		if err := c.runFor(ctx, q.delay); err != nil {
+			return nil, err
+		}
+
+		if q.stateful != nil {
+			c.mu.Lock()
+			q.stateful.calls++
+			n := q.stateful.calls
+			c.mu.Unlock()
+
+			return q.stateful.gen(n)
+		}
+
+		if q.sequence != nil {
+			return c.nextInSequence(query, q.sequence)
 		}
-		return q.rows, q.err
+
+		if q.lazy != nil {
+			return &lazyRows{gen: q.lazy, args: values(args)}, nil
+		}
+
+		return cloneRows(q.rows), q.err
 	}
-	return nil, errors.New("Query not stubbed: " + query)
+
+	if r, ok := c.seeded(query); ok {
+		return r, nil
+	}
+
+	if q, ok := c.verbArityStubs[verbArity{leadingVerb(query), len(args)}]; ok {
+		return verbRows(q)
+	}
+
+	if q, ok := c.verbStubs[leadingVerb(query)]; ok {
+		return verbRows(q)
+	}
+
+	if c.always != nil {
+		return cloneRows(c.always.rows), c.always.err
+	}
+
+	c.recordUnexpected(query)
+	if c.missingStubBehavior == MissingStubEmptyRows {
+		return RowsFromSlice(c.defaultCols[hash], nil), nil
+	}
+
+	return nil, c.notStubbed("Query not stubbed: ", query, c.queries)
 }
 
-func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
+func (c *conn) exec(ctx context.Context, query string, args []driver.NamedValue, prepared bool) (driver.Result, error) {
+	c.logf("exec %s", c.record(CallExec, query, args, prepared))
+	c.notify(func(o Observer) { o.OnExec(query, values(args)) })
+	defer c.recordDuration(query, now())
+
+	if c.isDryRun() {
+		return NewResult(0, nil, 0, nil), nil
+	}
+
+	if err := c.checkNumInput(query, args); err != nil {
+		return nil, err
+	}
+
+	if err := c.serializationFailure(query); err != nil {
+		return nil, err
+	}
+
+	if err := c.badConn(query); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkKind(query, CallExec); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkReadOnly(query); err != nil {
+		return nil, err
+	}
+
+	if step, ok, err := c.scriptStep(stepExec, query); ok {
+		if err != nil {
+			return nil, err
+		}
+		return step.result, step.err
+	}
+
+	var copied int64
+	if isCopyFromStdin(query) {
+		if len(args) > 0 {
+			c.copyRow(query, args)
+			return NewRowsAffectedResult(0), nil
+		}
+		copied = c.flushCopy(query)
+	}
+
 	if c.execFunc != nil {
-		return c.execFunc(query, args)
+		return c.execFunc(query, values(args))
 	}
 
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
+	if q, ok := c.execs[c.hash(query)]; ok && q.forExec() && c.use(q) {
+		c.markUsed(q)
+
+		if err := c.runFor(ctx, q.delay); err != nil {
+			return nil, err
+		}
+
+		if q.execFn != nil {
+			return q.execFn(values(args))
+		}
+		if q.nextID != nil {
+			return NewResult(c.increment(q.nextID), nil, 1, nil), nil
+		}
 		if q.result != nil {
 			return q.result, nil
-		} else if q.err != nil {
+		}
+		return nil, q.err
+	}
+
+	if q, ok := c.verbArityStubs[verbArity{leadingVerb(query), len(args)}]; ok {
+		if q.err != nil {
 			return nil, q.err
 		}
+		return NewResult(0, nil, 0, nil), nil
 	}
 
-	return nil, errors.New("Exec call not stubbed: " + query)
+	if q, ok := c.verbStubs[leadingVerb(query)]; ok {
+		if q.err != nil {
+			return nil, q.err
+		}
+		return NewResult(0, nil, 0, nil), nil
+	}
+
+	if isCopyFromStdin(query) {
+		return NewRowsAffectedResult(copied), nil
+	}
+
+	if c.always != nil {
+		if c.always.err != nil {
+			return nil, c.always.err
+		}
+		return NewResult(0, nil, 0, nil), nil
+	}
+
+	c.recordUnexpected(query)
+	if c.missingStubBehavior == MissingStubEmptyRows {
+		return NewResult(0, nil, 0, nil), nil
+	}
+
+	return nil, c.notStubbed("Exec call not stubbed: ", query, c.execs)
+}
+
+func (c *conn) increment(next *int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := *next
+	*next++
+	return id
+}
+
+func (c *conn) checkTx(query string, q query) error {
+	if q.requireTx == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	inTx := c.openTxs > 0
+	c.mu.Unlock()
+
+	if *q.requireTx && !inTx {
+		return errors.New("testdb: " + query + " must run inside a transaction")
+	} else if !*q.requireTx && inTx {
+		return errors.New("testdb: " + query + " must not run inside a transaction")
+	}
+	return nil
+}
+
+func (c *conn) endTx(readOnly bool) {
+	c.mu.Lock()
+	// Transactions begun before a Reset() aren't counted any more
+	if c.openTxs > 0 {
+		c.openTxs--
+	}
+	if readOnly && c.readOnlyTxs > 0 {
+		c.readOnlyTxs--
+	}
+	c.mu.Unlock()
+}
+
+func (c *conn) checkNumInput(query string, args []driver.NamedValue) error {
+	if n, ok := c.numInputs[c.hash(query)]; ok && n != len(args) {
+		return fmt.Errorf("testdb: %s expects %d arguments, got %d", query, n, len(args))
+	}
+	return nil
+}
+
+func (c *conn) isStubbed(hash string) bool {
+	_, query := c.queries[hash]
+	_, exec := c.execs[hash]
+	return query || exec || len(c.argMatchers[hash]) > 0 || len(c.ctxMatchers[hash]) > 0 || len(c.argStubs[hash]) > 0
+}
+
+// Identifies a list of arguments by type as well as value, so 1 and "1" are different keys.
+func argsKey(args []driver.Value) string {
+	return fmt.Sprintf("%#v", args)
+}
+
+// Reports whether a verb stub could answer the query, the number of arguments isn't known until the statement runs so any arity counts.
+func (c *conn) isVerbStubbed(query string) bool {
+	verb := leadingVerb(query)
+	if _, ok := c.verbStubs[verb]; ok {
+		return true
+	}
+
+	for key := range c.verbArityStubs {
+		if key.verb == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *conn) markUsed(q query) {
+	c.mu.Lock()
+	*q.used = true
+	c.mu.Unlock()
+}
+
+func (c *conn) recordUnexpected(query string) {
+	c.mu.Lock()
+	c.unexpected = append(c.unexpected, query)
+	t := c.failFast
+	c.mu.Unlock()
+
+	if t != nil {
+		t.Fatalf("testdb: unexpected query %s, called from %s", query, callSite())
+	}
+}
+
+func (c *conn) nextInSequence(query string, seq *querySequence) (driver.Rows, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if seq.pos >= len(seq.results) {
+		if c.errorAfterSequence || len(seq.results) == 0 {
+			return nil, errors.New("Query sequence exhausted: " + query)
+		}
+		seq.pos = len(seq.results) - 1
+	}
+
+	r := seq.results[seq.pos]
+	seq.pos++
+
+	return cloneRows(r.Rows), r.Err
+}
+
+// Returns a fresh copy of rows built by this package so stubs can be queried more than once.
+func cloneRows(r driver.Rows) driver.Rows {
+	switch rs := r.(type) {
+	case *rows:
+		return rs.clone()
+	case *generatedRows:
+		c := *rs
+		c.pos = 0
+		return &c
+	case *multiRows:
+		return rs.clone()
+	}
+	return r
+}
+
+// Returns the rows of a verb stub, which can be stubbed with neither rows nor an error for the sake of db.Exec(), in which case db.Query() gets a result without any rows.
+func verbRows(q query) (driver.Rows, error) {
+	if q.rows == nil && q.err == nil {
+		return RowsFromSlice(nil, nil), nil
+	}
+	return cloneRows(q.rows), q.err
 }
@@ -0,0 +1,58 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"strings"
+)
+
+// Reports whether the query is a COPY ... FROM STDIN statement, as prepared by pq.CopyIn().
+func isCopyFromStdin(query string) bool {
+	if leadingVerb(query) != "copy" {
+		return false
+	}
+
+	var words []string
+	for _, t := range tokenize(query) {
+		if t.kind == tokenWord {
+			words = append(words, strings.ToLower(t.text))
+		}
+	}
+
+	for i := 1; i < len(words); i++ {
+		if words[i-1] == "from" && words[i] == "stdin" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *conn) copyRow(query string, args []driver.NamedValue) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := c.hash(query)
+	c.copies[hash] = append(c.copies[hash], values(args))
+	c.copyPending[hash]++
+}
+
+// Returns the number of rows copied since the last flush.
+func (c *conn) flushCopy(query string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := c.hash(query)
+	n := c.copyPending[hash]
+	delete(c.copyPending, hash)
+
+	return int64(n)
+}
+
+// Returns every row copied with the COPY ... FROM STDIN query, in the order they were sent.
+//
+// This follows the protocol used by pq.CopyIn(): the COPY statement is prepared, each row is sent with an Exec() of the statement with the row's values as arguments, and a final Exec() without any arguments flushes the rows. COPY statements can be prepared without being stubbed, row Exec() calls always succeed and the flush returns a Result with the number of rows copied since the previous flush, unless the query has been stubbed with StubExec() or StubExecError().
+func CopiedRows(query string) [][]driver.Value {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return append([][]driver.Value(nil), d.conn.copies[d.conn.hash(query)]...)
+}
@@ -0,0 +1,80 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCopyIn(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := `COPY "users" ("id", "name") FROM STDIN`
+
+	tx, _ := db.Begin()
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []struct {
+		id   int64
+		name string
+	}{{1, "tim"}, {2, "joe"}} {
+		if _, err := stmt.Exec(u.id, u.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := stmt.Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("the flush should report the rows copied, got %d", n)
+	}
+
+	stmt.Close()
+	tx.Commit()
+
+	expected := [][]driver.Value{{int64(1), "tim"}, {int64(2), "joe"}}
+	if got := CopiedRows(`copy "users" ("id", "name") from stdin`); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	calls := Calls()
+	if len(calls) != 3 || len(calls[2].Args) != 0 {
+		t.Fatal("the flush should be recorded as an exec without arguments")
+	}
+}
+
+func TestCopyInFlushError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := `COPY "users" ("id") FROM STDIN`
+	StubExecError(query, errors.New("duplicate key"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stmt.Exec(); err == nil || err.Error() != "duplicate key" {
+		t.Fatal("a stubbed error should be returned from the flush")
+	}
+
+	if len(CopiedRows(query)) != 1 {
+		t.Fatal("rows should still be captured")
+	}
+}
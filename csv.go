@@ -0,0 +1,426 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The type a column of a typed CSV string is converted to, see RowsFromTypedCSVString().
+type CSVKind string
+
+const (
+	// Keeps the value as a string.
+	CSVString CSVKind = "string"
+	// Parses the value as an int64.
+	CSVInt CSVKind = "int"
+	// Parses the value as a float64. Use CSVDecimal instead when the exact digits matter, 1.50 comes back as 1.5.
+	CSVFloat CSVKind = "float"
+	// Parses the value with strconv.ParseBool.
+	CSVBool CSVKind = "bool"
+	// Parses the value as a time.Time, in RFC3339, "2006-01-02 15:04:05" or "2006-01-02" format.
+	CSVTime CSVKind = "time"
+	// Keeps a numeric value as the exact string given, 1.50 stays "1.50". This suits money and other values scanned into decimal types such as shopspring/decimal, avoiding float rounding.
+	CSVDecimal CSVKind = "decimal"
+)
+
+var csvTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+func readCSV(s string, c []rune) ([][]string, error) {
+	csvReader := csv.NewReader(strings.NewReader(strings.TrimSpace(s)))
+	if len(c) > 0 {
+		csvReader.Comma = c[0]
+	}
+
+	var records [][]string
+	for {
+		r, err := csvReader.Read()
+		if err == io.EOF {
+			return records, nil
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+}
+
+// Same as RowsFromCSVString(), but converts each column to the kind given for it. Empty values in columns that aren't CSVString become NULL. A value that can't be converted returns an error. sql.ColumnType reports the scan type and database type name of each kind, and that every column but a CSVString one is nullable.
+func RowsFromTypedCSVString(columns []string, kinds []CSVKind, s string, c ...rune) (driver.Rows, error) {
+	if len(kinds) != len(columns) {
+		return nil, fmt.Errorf("testdb: got %d kinds for %d columns", len(kinds), len(columns))
+	}
+
+	records, err := readCSV(s, c)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: row %d has %d values, expected %d", i+1, len(record), len(columns))
+		}
+
+		data[i] = make([]driver.Value, len(columns))
+		for j, v := range record {
+			val, err := convertCSVValue(strings.TrimSpace(v), kinds[j])
+			if err != nil {
+				return nil, fmt.Errorf("testdb: row %d column %q: %s", i+1, columns[j], err)
+			}
+			data[i][j] = val
+		}
+	}
+
+	defs := make([]ColumnDef, len(columns))
+	for i, col := range columns {
+		defs[i] = ColumnDef{Name: col, ScanType: kinds[i].scanType(), Nullable: kinds[i] != CSVString, DBTypeName: kinds[i].dbTypeName()}
+	}
+
+	return NewTypedRows(defs, data), nil
+}
+
+// The database type names RowsFromTypedCSVString() reports for each kind, unless replaced with SetCSVTypeNames().
+var csvTypeNames = map[CSVKind]string{
+	CSVString:  "VARCHAR",
+	CSVInt:     "INTEGER",
+	CSVFloat:   "DOUBLE",
+	CSVBool:    "BOOLEAN",
+	CSVTime:    "TIMESTAMP",
+	CSVDecimal: "DECIMAL",
+}
+
+// Replaces the database type names RowsFromTypedCSVString() reports for the kinds in names, to match the dialect the code under test expects, such as "INT8" or "TIMESTAMPTZ" for Postgres. Kinds missing from names keep their default names. The names apply to rows built afterwards, until Reset() is called.
+func SetCSVTypeNames(names map[CSVKind]string) {
+	d.csvTypeNames = names
+}
+
+func (k CSVKind) dbTypeName() string {
+	if name, ok := d.csvTypeNames[k]; ok {
+		return name
+	}
+	return csvTypeNames[k]
+}
+
+func (k CSVKind) scanType() reflect.Type {
+	switch k {
+	case CSVInt:
+		return reflect.TypeOf(int64(0))
+	case CSVFloat:
+		return reflect.TypeOf(float64(0))
+	case CSVBool:
+		return reflect.TypeOf(false)
+	case CSVTime:
+		return reflect.TypeOf(time.Time{})
+	}
+	return reflect.TypeOf("")
+}
+
+// Same as RowsFromCSVString(), but the values of every column named in converters are converted by its function, for types such as UUIDs, enums or money. Other columns are read as RowsFromCSVString() reads them. Panics if a converter returns an error, see RowsFromCSVStringWithConvertersE.
+func RowsFromCSVStringWithConverters(columns []string, s string, converters map[string]func(string) (driver.Value, error), c ...rune) driver.Rows {
+	rows, err := RowsFromCSVStringWithConvertersE(columns, s, converters, c...)
+	if err != nil {
+		panic(err)
+	}
+	return rows
+}
+
+// Same as RowsFromCSVStringWithConverters(), but returns the error of a converter, or one for a converter naming a column that doesn't exist, instead of panicking.
+func RowsFromCSVStringWithConvertersE(columns []string, s string, converters map[string]func(string) (driver.Value, error), c ...rune) (driver.Rows, error) {
+	for name := range converters {
+		if !slices.Contains(columns, name) {
+			return nil, fmt.Errorf("testdb: converter for unknown column %q", name)
+		}
+	}
+
+	convert := make([]func(string) (driver.Value, error), len(columns))
+	for i, col := range columns {
+		convert[i] = converters[col]
+	}
+
+	records, err := readCSV(s, c)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: row %d has %d values, expected %d", i+1, len(record), len(columns))
+		}
+
+		data[i] = make([]driver.Value, len(columns))
+		for j, v := range record {
+			v = strings.TrimSpace(v)
+			if convert[j] == nil {
+				data[i][j] = parseCSVValue(v)
+				continue
+			}
+
+			val, err := convert[j](v)
+			if err != nil {
+				return nil, fmt.Errorf("testdb: row %d column %q: %w", i+1, columns[j], err)
+			}
+			data[i][j] = val
+		}
+	}
+
+	return RowsFromSlice(columns, data), nil
+}
+
+// How the values of a column are changed before they are parsed by RowsFromCSVStringWithPolicies().
+type CSVColumnPolicy struct {
+	// Keeps the whitespace around the values, which are trimmed by default.
+	KeepSpace bool
+	Case      CSVCase
+}
+
+// The case a CSVColumnPolicy converts values to.
+type CSVCase int
+
+const (
+	// Values keep their case, this is the default.
+	CSVKeepCase CSVCase = iota
+	CSVUpperCase
+	CSVLowerCase
+)
+
+func (p CSVColumnPolicy) apply(v string) string {
+	if !p.KeepSpace {
+		v = strings.TrimSpace(v)
+	}
+
+	switch p.Case {
+	case CSVUpperCase:
+		v = strings.ToUpper(v)
+	case CSVLowerCase:
+		v = strings.ToLower(v)
+	}
+	return v
+}
+
+// Same as RowsFromCSVString(), but the values of every column named in policies are trimmed and case folded as its policy says, columns without a policy are trimmed as RowsFromCSVString() trims them. Whitespace before the first value and after the last one of the whole string is always removed, quote a value to keep it. Returns an error for a policy naming a column that doesn't exist, or a row that doesn't have one value per column.
+func RowsFromCSVStringWithPolicies(columns []string, s string, policies map[string]CSVColumnPolicy, c ...rune) (driver.Rows, error) {
+	for name := range policies {
+		if !slices.Contains(columns, name) {
+			return nil, fmt.Errorf("testdb: policy for unknown column %q", name)
+		}
+	}
+
+	records, err := readCSV(s, c)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: row %d has %d values, expected %d", i+1, len(record), len(columns))
+		}
+
+		data[i] = make([]driver.Value, len(columns))
+		for j, v := range record {
+			data[i][j] = parseCSVValue(policies[columns[j]].apply(v))
+		}
+	}
+
+	return RowsFromSlice(columns, data), nil
+}
+
+func convertCSVValue(v string, kind CSVKind) (driver.Value, error) {
+	if v == "" && kind != CSVString {
+		return nil, nil
+	}
+
+	switch kind {
+	case CSVString:
+		return v, nil
+	case CSVInt:
+		return strconv.ParseInt(v, 10, 64)
+	case CSVFloat:
+		return strconv.ParseFloat(v, 64)
+	case CSVBool:
+		return strconv.ParseBool(v)
+	case CSVTime:
+		for _, layout := range csvTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("can't parse %q as a time", v)
+	case CSVDecimal:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a decimal", v)
+		}
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+// Same as RowsFromCSVString(), but values that look like a time are always parsed. Times with an offset, such as RFC3339, keep their zone and times without one are interpreted in loc.
+func RowsFromCSVStringInLocation(columns []string, s string, loc *time.Location, c ...rune) driver.Rows {
+	return rowsFromCSV(columns, s, c, func(v string) driver.Value {
+		for _, layout := range csvTimeLayouts {
+			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return t
+			}
+		}
+		return v
+	})
+}
+
+// Same as RowsFromCSVString(), but the first line of s holds the column names instead of them being passed separately. A header without any data lines returns no rows.
+func RowsFromCSVWithHeader(s string, c ...rune) driver.Rows {
+	header, body, _ := strings.Cut(strings.TrimSpace(s), "\n")
+
+	records, _ := readCSV(header, c)
+
+	var columns []string
+	if len(records) > 0 {
+		for _, col := range records[0] {
+			columns = append(columns, strings.TrimSpace(col))
+		}
+	}
+
+	return RowsFromCSVString(columns, body, c...)
+}
+
+// Reads the named CSV file from fsys, such as an embed.FS, and parses it the same way as RowsFromCSVString(). Returns an error if the file can't be read, isn't valid CSV or a record doesn't have one value per column.
+func RowsFromCSVFS(fsys fs.FS, name string, columns []string, c ...rune) (driver.Rows, error) {
+	b, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("testdb: reading fixture: %w", err)
+	}
+
+	records, err := readCSV(string(b), c)
+	if err != nil {
+		return nil, fmt.Errorf("testdb: parsing fixture %s: %w", name, err)
+	}
+
+	data := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: fixture %s record %d has %d values for %d columns", name, i+1, len(record), len(columns))
+		}
+
+		data[i] = make([]driver.Value, len(record))
+		for j, v := range record {
+			data[i][j] = parseCSVValue(strings.TrimSpace(v))
+		}
+	}
+
+	return RowsFromSlice(columns, data), nil
+}
+
+// The token RowsFromCSVStringWithNulls() reads as NULL, the one Postgres COPY uses.
+const CSVNullToken = `\N`
+
+// Same as RowsFromCSVString(), but follows the NULL rules of Postgres COPY in CSV format. An unquoted empty value or an unquoted \N is NULL, while a quoted value is always a string, so "" is an empty string and "\N" is the two characters. Quoted values are kept exactly, spaces included. Returns an error if a row doesn't have one value per column or a quote isn't closed.
+func RowsFromCSVStringWithNulls(columns []string, s string, c ...rune) (driver.Rows, error) {
+	return RowsFromCSVStringWithNullToken(columns, s, CSVNullToken, c...)
+}
+
+// Same as RowsFromCSVStringWithNulls(), but unquoted values equal to token are NULL instead of \N. An empty token leaves only unquoted empty values as NULL.
+func RowsFromCSVStringWithNullToken(columns []string, s string, token string, c ...rune) (driver.Rows, error) {
+	comma := ','
+	if len(c) > 0 {
+		comma = c[0]
+	}
+
+	records, err := readQuotedCSV(strings.TrimSpace(s), comma)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: row %d has %d values, expected %d", i+1, len(record), len(columns))
+		}
+
+		data[i] = make([]driver.Value, len(columns))
+		for j, f := range record {
+			if !f.quoted && (f.value == "" || f.value == token) {
+				continue
+			}
+			data[i][j] = parseCSVValue(f.value)
+		}
+	}
+
+	return RowsFromSlice(columns, data), nil
+}
+
+type csvField struct {
+	value  string
+	quoted bool
+}
+
+// Splits s into records like encoding/csv, but remembers which fields were quoted, as encoding/csv reads "" and an empty field the same. Blank lines are skipped and spaces around unquoted values are trimmed.
+func readQuotedCSV(s string, comma rune) ([][]csvField, error) {
+	var records [][]csvField
+	var record []csvField
+	r := []rune(s)
+
+	for i := 0; i <= len(r); {
+		for i < len(r) && (r[i] == ' ' || r[i] == '\t') && r[i] != comma {
+			i++
+		}
+
+		var f csvField
+		if i < len(r) && r[i] == '"' {
+			f.quoted = true
+			var b strings.Builder
+			for i++; ; i++ {
+				if i >= len(r) {
+					return nil, fmt.Errorf("testdb: record %d has an unclosed quote", len(records)+1)
+				}
+				if r[i] == '"' {
+					if i+1 < len(r) && r[i+1] == '"' {
+						i++
+					} else {
+						i++
+						break
+					}
+				}
+				b.WriteRune(r[i])
+			}
+			f.value = b.String()
+
+			for i < len(r) && r[i] != comma && r[i] != '\n' {
+				if !unicode.IsSpace(r[i]) {
+					return nil, fmt.Errorf("testdb: record %d has text after a closing quote", len(records)+1)
+				}
+				i++
+			}
+		} else {
+			start := i
+			for i < len(r) && r[i] != comma && r[i] != '\n' {
+				i++
+			}
+			f.value = strings.TrimSpace(string(r[start:i]))
+		}
+		record = append(record, f)
+
+		if i < len(r) && r[i] == comma {
+			i++
+			continue
+		}
+
+		// A line holding nothing at all is skipped rather than read as a single NULL
+		if len(record) > 1 || record[0].quoted || record[0].value != "" {
+			records = append(records, record)
+		}
+		record = nil
+		i++
+	}
+
+	return records, nil
+}
@@ -0,0 +1,391 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRowsFromTypedCSVString(t *testing.T) {
+	r, err := RowsFromTypedCSVString(
+		[]string{"id", "name", "score", "price", "active", "born"},
+		[]CSVKind{CSVInt, CSVString, CSVFloat, CSVDecimal, CSVBool, CSVTime},
+		`
+  1,tim,1.50,1.50,true,2012-10-01 01:00:01
+  2,joe,,,false,2012-10-02
+  `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{
+		{int64(1), "tim", 1.5, "1.50", true, time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)},
+		{int64(2), "joe", nil, nil, false, time.Date(2012, 10, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromTypedCSVStringColumnTypes(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	kinds := []CSVKind{CSVInt, CSVString, CSVFloat, CSVDecimal, CSVBool, CSVTime}
+	r, err := RowsFromTypedCSVString([]string{"id", "name", "score", "price", "active", "born"}, kinds, "1,tim,1.5,1.50,true,2012-10-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	StubQuery("select * from users", r)
+
+	res, err := db.Query("select * from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	types, err := res.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []reflect.Type{
+		reflect.TypeOf(int64(0)),
+		reflect.TypeOf(""),
+		reflect.TypeOf(float64(0)),
+		reflect.TypeOf(""),
+		reflect.TypeOf(false),
+		reflect.TypeOf(time.Time{}),
+	}
+	for i, ct := range types {
+		if ct.ScanType() != expected[i] {
+			t.Fatalf("column %s: expected scan type %v, got %v", ct.Name(), expected[i], ct.ScanType())
+		}
+
+		if nullable, ok := ct.Nullable(); !ok || nullable != (kinds[i] != CSVString) {
+			t.Fatalf("column %s: unexpected nullability %v", ct.Name(), nullable)
+		}
+	}
+}
+
+func TestRowsFromTypedCSVStringTypeNames(t *testing.T) {
+	defer Reset()
+
+	kinds := []CSVKind{CSVInt, CSVString, CSVFloat, CSVDecimal, CSVBool, CSVTime}
+	columns := []string{"id", "name", "score", "price", "active", "born"}
+
+	typeNames := func() []string {
+		r, err := RowsFromTypedCSVString(columns, kinds, "1,tim,1.5,1.50,true,2012-10-01")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		types, err := AsSQLRows(t, r).ColumnTypes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		names := make([]string, len(types))
+		for i, ct := range types {
+			names[i] = ct.DatabaseTypeName()
+		}
+		return names
+	}
+
+	expected := []string{"INTEGER", "VARCHAR", "DOUBLE", "DECIMAL", "BOOLEAN", "TIMESTAMP"}
+	if names := typeNames(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+
+	SetCSVTypeNames(map[CSVKind]string{CSVInt: "INT8", CSVTime: "TIMESTAMPTZ"})
+	expected = []string{"INT8", "VARCHAR", "DOUBLE", "DECIMAL", "BOOLEAN", "TIMESTAMPTZ"}
+	if names := typeNames(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected the overridden names %v, got %v", expected, names)
+	}
+}
+
+func TestRowsFromTypedCSVStringDecimal(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := RowsFromTypedCSVString([]string{"price"}, []CSVKind{CSVDecimal}, "19.90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	StubQuery("select price from products", rows)
+
+	var price string
+	if err := db.QueryRow("select price from products").Scan(&price); err != nil {
+		t.Fatal(err)
+	}
+
+	if price != "19.90" {
+		t.Fatalf("decimal should keep its exact digits, got %s", price)
+	}
+}
+
+func TestRowsFromTypedCSVStringErrors(t *testing.T) {
+	if _, err := RowsFromTypedCSVString([]string{"id"}, []CSVKind{CSVInt}, "abc"); err == nil {
+		t.Fatal("invalid ints should return an error")
+	}
+
+	if _, err := RowsFromTypedCSVString([]string{"price"}, []CSVKind{CSVDecimal}, "1.2.3"); err == nil {
+		t.Fatal("invalid decimals should return an error")
+	}
+
+	if _, err := RowsFromTypedCSVString([]string{"id", "name"}, []CSVKind{CSVInt}, "1,tim"); err == nil {
+		t.Fatal("a kind is required for every column")
+	}
+}
+
+func TestRowsFromCSVStringInLocation(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	r := RowsFromCSVStringInLocation([]string{"id", "created", "zoned", "day"}, `
+1,2012-10-01 01:00:01,2012-10-01T01:00:01+02:00,2012-10-01
+`, ny)
+
+	row := r.(*rows).rows[0]
+
+	created, ok := row[1].(time.Time)
+	if !ok || !created.Equal(time.Date(2012, 10, 1, 1, 0, 1, 0, ny)) || created.Location() != ny {
+		t.Fatalf("naive times should be interpreted in the location, got %v", row[1])
+	}
+
+	zoned, ok := row[2].(time.Time)
+	if !ok || !zoned.Equal(time.Date(2012, 9, 30, 23, 0, 1, 0, time.UTC)) {
+		t.Fatalf("times with an offset should keep it, got %v", row[2])
+	}
+	if _, offset := zoned.Zone(); offset != 2*60*60 {
+		t.Fatalf("expected a +02:00 offset, got %d", offset)
+	}
+
+	day, ok := row[3].(time.Time)
+	if !ok || !day.Equal(time.Date(2012, 10, 1, 0, 0, 0, 0, ny)) {
+		t.Fatalf("dates should be midnight in the location, got %v", row[3])
+	}
+
+	if row[0] != "1" {
+		t.Fatalf("other values should be left as strings, got %v", row[0])
+	}
+
+	utc := RowsFromCSVStringInLocation([]string{"created"}, "2012-10-01 01:00:01", time.UTC)
+	if created := utc.(*rows).rows[0][0].(time.Time); !created.Equal(time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)) || created.Location() != time.UTC {
+		t.Fatalf("naive times should be UTC when the location is, got %v", created)
+	}
+}
+
+func TestRowsFromCSVWithHeader(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select id, name from users", RowsFromCSVWithHeader(`
+id , name
+1,tim
+2,joe
+`))
+
+	res, err := db.Query("select id, name from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	columns, _ := res.Columns()
+	if !reflect.DeepEqual(columns, []string{"id", "name"}) {
+		t.Fatalf("header should be trimmed into the columns, got %q", columns)
+	}
+
+	var names []string
+	for res.Next() {
+		var id int64
+		var name string
+		if err := res.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"tim", "joe"}) {
+		t.Fatalf("unexpected rows %v", names)
+	}
+}
+
+func TestRowsFromCSVWithHeaderOnly(t *testing.T) {
+	r := RowsFromCSVWithHeader("id|name", '|')
+
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name"}) {
+		t.Fatalf("unexpected columns %v", r.Columns())
+	}
+
+	if len(r.(*rows).rows) != 0 {
+		t.Fatal("a header without data should return no rows")
+	}
+}
+
+func TestRowsFromCSVStringRaw(t *testing.T) {
+	defer EnableTimeParsing(false)
+	EnableTimeParsing(true)
+
+	data := "v1,2012-10-01T01:00:01Z"
+
+	if _, ok := RowsFromCSVString([]string{"version", "serial"}, data).(*rows).rows[0][1].(time.Time); !ok {
+		t.Fatal("RowsFromCSVString should parse times when time parsing is enabled")
+	}
+
+	r := RowsFromCSVStringRaw([]string{"version", "serial"}, data)
+	if serial := r.(*rows).rows[0][1]; serial != "2012-10-01T01:00:01Z" {
+		t.Fatalf("date like values should stay strings, got %#v", serial)
+	}
+}
+
+func TestRowsFromCSVFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.csv":  {Data: []byte("1,tim\n2,joe\n")},
+		"fixtures/broken.csv": {Data: []byte("1,\"tim\n")},
+		"fixtures/ragged.csv": {Data: []byte("1,tim,20\n")},
+	}
+
+	r, err := RowsFromCSVFS(fsys, "fixtures/users.csv", []string{"id", "name"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{{"1", "tim"}, {"2", "joe"}}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+
+	if _, err := RowsFromCSVFS(fsys, "fixtures/missing.csv", []string{"id"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+
+	if _, err := RowsFromCSVFS(fsys, "fixtures/broken.csv", []string{"id", "name"}); err == nil {
+		t.Fatal("invalid CSV should return an error")
+	}
+
+	if _, err := RowsFromCSVFS(fsys, "fixtures/ragged.csv", []string{"id", "name"}); err == nil {
+		t.Fatal("records with the wrong number of values should return an error")
+	}
+}
+
+func TestRowsFromCSVStringWithNulls(t *testing.T) {
+	r, err := RowsFromCSVStringWithNulls([]string{"id", "name", "nickname", "note"}, `
+  1,,"",\N
+  2,"\N"," spaced ","say ""hi"", then go"
+  `)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{
+		{"1", nil, "", nil},
+		{"2", `\N`, " spaced ", `say "hi", then go`},
+	}
+
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %q, got %q", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromCSVStringWithNullToken(t *testing.T) {
+	r, err := RowsFromCSVStringWithNullToken([]string{"id", "name"}, "1|NULL\n2|\"NULL\"\n3|\\N", "NULL", '|')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{{"1", nil}, {"2", "NULL"}, {"3", `\N`}}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %q, got %q", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromCSVStringWithNullsErrors(t *testing.T) {
+	if _, err := RowsFromCSVStringWithNulls([]string{"id", "name"}, `1,"tim`); err == nil {
+		t.Fatal("an unclosed quote should return an error")
+	}
+
+	if _, err := RowsFromCSVStringWithNulls([]string{"id", "name"}, "1"); err == nil {
+		t.Fatal("a row with missing values should return an error")
+	}
+}
+
+func parseUUID(s string) (driver.Value, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("%q is not a UUID", s)
+	}
+	return b, nil
+}
+
+func TestRowsFromCSVStringWithConverters(t *testing.T) {
+	r := RowsFromCSVStringWithConverters([]string{"id", "name"}, `
+  6ba7b810-9dad-11d1-80b4-00c04fd430c8,tim
+  `, map[string]func(string) (driver.Value, error){"id": parseUUID})
+
+	id, _ := hex.DecodeString("6ba7b8109dad11d180b400c04fd430c8")
+	expected := [][]driver.Value{{id, "tim"}}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromCSVStringWithConvertersE(t *testing.T) {
+	converters := map[string]func(string) (driver.Value, error){"id": parseUUID}
+
+	if _, err := RowsFromCSVStringWithConvertersE([]string{"id"}, "not-a-uuid", converters); err == nil || !strings.Contains(err.Error(), "not a UUID") {
+		t.Fatalf("the converter's error should be returned, got %v", err)
+	}
+
+	if _, err := RowsFromCSVStringWithConvertersE([]string{"uuid"}, "1", converters); err == nil {
+		t.Fatal("a converter for a column that doesn't exist should return an error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RowsFromCSVStringWithConverters should panic on a conversion error")
+		}
+	}()
+	RowsFromCSVStringWithConverters([]string{"id"}, "not-a-uuid", converters)
+}
+
+func TestRowsFromCSVStringWithPolicies(t *testing.T) {
+	r, err := RowsFromCSVStringWithPolicies([]string{"id", "status", "email", "note"}, `
+1, active ,  Tim@Example.com ," padded "
+2,Pending,JOE@example.com, plain
+`, map[string]CSVColumnPolicy{
+		"status": {Case: CSVUpperCase},
+		"email":  {Case: CSVLowerCase},
+		"note":   {KeepSpace: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{
+		{"1", "ACTIVE", "tim@example.com", " padded "},
+		{"2", "PENDING", "joe@example.com", " plain"},
+	}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %q, got %q", expected, r.(*rows).rows)
+	}
+
+	if _, err := RowsFromCSVStringWithPolicies([]string{"id"}, "1", map[string]CSVColumnPolicy{"name": {}}); err == nil {
+		t.Fatal("a policy for a column that doesn't exist should return an error")
+	}
+}
@@ -0,0 +1,63 @@
+package testdb
+
+import (
+	"errors"
+	"strings"
+)
+
+// Builds the error for a call that didn't match any stub, showing the normalized query and the closest stub so it's clear why nothing matched. The same details are logged.
+func (c *conn) notStubbed(message, query string, stubs map[string]query) error {
+	var b strings.Builder
+	b.WriteString(message + query)
+
+	normalized := c.normalized(query)
+	b.WriteString("\n\tnormalized: " + normalized)
+
+	if closest, ok := c.closestStub(normalized, stubs); ok {
+		b.WriteString("\n\tclosest stub: " + closest + "\n\tnormalized:   " + c.normalized(closest))
+	}
+
+	c.logf("%s", b.String())
+	return errors.New(b.String())
+}
+
+// Returns the text of the stub whose normalized form is nearest to normalized, as long as it is close enough to be a likely typo rather than a different query.
+func (c *conn) closestStub(normalized string, stubs map[string]query) (string, bool) {
+	best, bestDistance := "", -1
+	for _, q := range stubs {
+		candidate := c.normalized(q.text)
+		dist := levenshtein(normalized, candidate)
+		if bestDistance < 0 || dist < bestDistance || (dist == bestDistance && q.text < best) {
+			best, bestDistance = q.text, dist
+		}
+	}
+
+	if bestDistance < 0 || bestDistance > len([]rune(normalized))/2 {
+		return "", false
+	}
+	return best, true
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
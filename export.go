@@ -0,0 +1,307 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+type exportedQuery struct {
+	// "exec" for the stubs of db.Exec(), empty for the ones of db.Query()
+	Kind    string          `json:"kind,omitempty"`
+	Query   string          `json:"query"`
+	Columns []string        `json:"columns,omitempty"`
+	Rows    [][]jsonValue   `json:"rows,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Result  *exportedResult `json:"result,omitempty"`
+	NextID  *int64          `json:"next_id,omitempty"`
+}
+
+const exportedExec = "exec"
+
+type exportedResult struct {
+	LastInsertId      int64  `json:"last_insert_id"`
+	LastInsertIdError string `json:"last_insert_id_error,omitempty"`
+	RowsAffected      int64  `json:"rows_affected"`
+	RowsAffectedError string `json:"rows_affected_error,omitempty"`
+}
+
+func exportResult(r *Result) *exportedResult {
+	e := &exportedResult{LastInsertId: r.lastInsertId, RowsAffected: r.rowsAffected}
+	if r.lastInsertIdError != nil {
+		e.LastInsertIdError = r.lastInsertIdError.Error()
+	}
+	if r.rowsAffectedError != nil {
+		e.RowsAffectedError = r.rowsAffectedError.Error()
+	}
+	return e
+}
+
+func (e *exportedResult) result() *Result {
+	r := &Result{lastInsertId: e.LastInsertId, rowsAffected: e.RowsAffected}
+	if e.LastInsertIdError == ErrLastInsertIdUnset.Error() {
+		r.lastInsertIdError = ErrLastInsertIdUnset
+	} else if e.LastInsertIdError != "" {
+		r.lastInsertIdError = errors.New(e.LastInsertIdError)
+	}
+	if e.RowsAffectedError != "" {
+		r.rowsAffectedError = errors.New(e.RowsAffectedError)
+	}
+	return r
+}
+
+// Configures how Export() writes stubs and Import() reads them back, the same options must be passed to both.
+type ExportOption func(*exportOptions)
+
+type exportOptions struct {
+	timeLayout string
+	utc        bool
+}
+
+func newExportOptions(opts []ExportOption) *exportOptions {
+	o := &exportOptions{timeLayout: time.RFC3339Nano}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Writes times with the supplied layout instead of time.RFC3339Nano.
+func WithTimeLayout(layout string) ExportOption {
+	return func(o *exportOptions) {
+		o.timeLayout = layout
+	}
+}
+
+// Converts times to UTC instead of keeping the name of their time zone, which is otherwise restored on import so a time in America/New_York comes back in America/New_York.
+func WithoutTimeZone() ExportOption {
+	return func(o *exportOptions) {
+		o.utc = true
+	}
+}
+
+// A driver.Value that keeps its type when written as JSON. Times and byte slices are written as objects so they aren't confused with strings when read back, and floats so a whole number such as 2.0 isn't read back as an int64.
+type jsonValue struct {
+	value driver.Value
+	opts  *exportOptions
+}
+
+type jsonTime struct {
+	Time   string `json:"time"`
+	Zone   string `json:"zone,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+func (o *exportOptions) formatTime(t time.Time) jsonTime {
+	if o.utc {
+		return jsonTime{Time: t.UTC().Format(o.timeLayout)}
+	}
+
+	jt := jsonTime{Time: t.Format(o.timeLayout)}
+	if t.Location() != time.UTC {
+		jt.Zone = t.Location().String()
+		// Zones made with time.FixedZone() can't be loaded by name, so their offset is kept too
+		if _, err := time.LoadLocation(jt.Zone); err != nil {
+			_, jt.Offset = t.Zone()
+		}
+	}
+	return jt
+}
+
+func (o *exportOptions) parseTime(jt jsonTime) (time.Time, error) {
+	if jt.Zone == "" {
+		return time.ParseInLocation(o.timeLayout, jt.Time, time.UTC)
+	}
+
+	loc, err := time.LoadLocation(jt.Zone)
+	if err != nil {
+		loc = time.FixedZone(jt.Zone, jt.Offset)
+	}
+	return time.ParseInLocation(o.timeLayout, jt.Time, loc)
+}
+
+type jsonBytes struct {
+	Bytes []byte `json:"bytes"`
+}
+
+type jsonFloat struct {
+	Float float64 `json:"float"`
+}
+
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	switch val := v.value.(type) {
+	case time.Time:
+		return json.Marshal(v.opts.formatTime(val))
+	case []byte:
+		return json.Marshal(jsonBytes{Bytes: val})
+	case float64:
+		return json.Marshal(jsonFloat{Float: val})
+	default:
+		return json.Marshal(val)
+	}
+}
+
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	switch val := raw.(type) {
+	case float64:
+		var i int64
+		if err := json.Unmarshal(data, &i); err == nil {
+			v.value = i
+		} else {
+			v.value = val
+		}
+	case map[string]interface{}:
+		if _, ok := val["time"]; ok {
+			var t jsonTime
+			if err := json.Unmarshal(data, &t); err != nil {
+				return err
+			}
+			// Parsed by Import(), which knows the layout
+			v.value = t
+		} else if _, ok := val["bytes"]; ok {
+			var b jsonBytes
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			v.value = b.Bytes
+		} else if f, ok := val["float"].(float64); ok {
+			v.value = f
+		} else {
+			return fmt.Errorf("testdb: unknown value %s", data)
+		}
+	default:
+		v.value = val
+	}
+
+	return nil
+}
+
+// Writes every query stubbed with rows or an error, and every statement stubbed for db.Exec() with a Result, an error or StubExecAutoIncrement(), to w as JSON, so they can be loaded again with Import(). Times are written in RFC3339 along with the name of their time zone unless options say otherwise. Queries stubbed with a driver.Rows that wasn't created by this package, and statements stubbed with StubExecFunc(), can't be exported and return an error.
+func Export(w io.Writer, opts ...ExportOption) error {
+	o := newExportOptions(opts)
+
+	var exported []exportedQuery
+
+	for _, q := range d.conn.queries {
+		if q.rows == nil && q.err == nil {
+			continue
+		}
+
+		e := exportedQuery{Query: q.text}
+		if q.err != nil {
+			e.Error = q.err.Error()
+		}
+
+		if q.rows != nil {
+			rs, ok := q.rows.(*rows)
+			if !ok {
+				return errors.New("testdb: can't export rows stubbed for " + q.text)
+			}
+
+			e.Columns = rs.columns
+			for _, row := range rs.rows {
+				values := make([]jsonValue, len(row))
+				for i, v := range row {
+					values[i] = jsonValue{value: v, opts: o}
+				}
+				e.Rows = append(e.Rows, values)
+			}
+		}
+
+		exported = append(exported, e)
+	}
+
+	for _, q := range d.conn.execs {
+		if q.execFn != nil {
+			return errors.New("testdb: can't export the function stubbed for " + q.text)
+		}
+		if q.result == nil && q.err == nil && q.nextID == nil {
+			continue
+		}
+
+		e := exportedQuery{Kind: exportedExec, Query: q.text, NextID: q.nextID}
+		if q.err != nil {
+			e.Error = q.err.Error()
+		}
+		if q.result != nil {
+			e.Result = exportResult(q.result)
+		}
+
+		exported = append(exported, e)
+	}
+
+	sort.Slice(exported, func(i, j int) bool {
+		if exported[i].Query == exported[j].Query {
+			return exported[i].Kind < exported[j].Kind
+		}
+		return exported[i].Query < exported[j].Query
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(exported)
+}
+
+// Reads stubs written by Export() from r and stubs each of them on the global driver.Conn. Pass the options the stubs were exported with.
+func Import(r io.Reader, opts ...ExportOption) error {
+	o := newExportOptions(opts)
+
+	var imported []exportedQuery
+	if err := json.NewDecoder(r).Decode(&imported); err != nil {
+		return err
+	}
+
+	for _, e := range imported {
+		q := query{}
+		if e.Error != "" {
+			q.err = errors.New(e.Error)
+		}
+
+		if e.Kind == exportedExec {
+			q.nextID = e.NextID
+			if e.Result != nil {
+				q.result = e.Result.result()
+			}
+			if err := d.conn.stubExec(e.Query, q); err != nil {
+				return err
+			}
+			continue
+		}
+		if e.Kind != "" {
+			return fmt.Errorf("testdb: unknown kind of stub %q for %s", e.Kind, e.Query)
+		}
+
+		if e.Columns != nil {
+			data := make([][]driver.Value, len(e.Rows))
+			for i, row := range e.Rows {
+				data[i] = make([]driver.Value, len(row))
+				for j, v := range row {
+					data[i][j] = v.value
+					if jt, ok := v.value.(jsonTime); ok {
+						t, err := o.parseTime(jt)
+						if err != nil {
+							return err
+						}
+						data[i][j] = t
+					}
+				}
+			}
+			q.rows = RowsFromSlice(e.Columns, data)
+		}
+
+		if err := d.conn.stub(e.Query, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
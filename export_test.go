@@ -0,0 +1,249 @@
+package testdb
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	defer Reset()
+
+	born := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	StubQuery("select id, name, born, score, active, avatar, note from users",
+		NewRows("id", "name", "born", "score", "active", "avatar", "note").
+			AddRow(1, "tim", born, 1.5, true, []byte("png"), nil).
+			Build())
+	StubQueryError("select count(*) from error", errors.New("test error"))
+
+	var buf bytes.Buffer
+	if err := Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `"time": "2012-10-01T01:00:01Z"`) {
+		t.Fatalf("times should be exported as RFC3339: %s", buf.String())
+	}
+
+	Reset()
+
+	if err := Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query("select id, name, born, score, active, avatar, note from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		t.Fatal("expected an imported row")
+	}
+
+	values := make([]interface{}, 7)
+	targets := make([]interface{}, 7)
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{int64(1), "tim", born, 1.5, true, []byte("png"), nil}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+
+	_, err = db.Query("select count(*) from error")
+	if err == nil || err.Error() != "test error" {
+		t.Fatal("imported error stub did not return expected error")
+	}
+}
+
+func TestExportImportFloats(t *testing.T) {
+	defer Reset()
+
+	query := "select score, id from scores"
+	StubQuery(query, RowsFromSlice([]string{"score", "id"}, [][]driver.Value{{2.0, int64(2)}, {-0.5, int64(3)}}))
+
+	var buf bytes.Buffer
+	if err := Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	Reset()
+
+	if err := Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got [][]interface{}
+	for rows.Next() {
+		var score, id interface{}
+		if err := rows.Scan(&score, &id); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, []interface{}{score, id})
+	}
+
+	expected := [][]interface{}{{2.0, int64(2)}, {-0.5, int64(3)}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, got)
+	}
+}
+
+func TestExportImportExec(t *testing.T) {
+	defer Reset()
+
+	StubQuery("select id from users", RowsFromSlice([]string{"id"}, [][]driver.Value{{1}}))
+	StubExecError("select id from users", errors.New("not a statement"))
+	StubExec("insert into users (name) values (?)", NewResult(5, nil, 1, nil))
+	StubExec("delete from users", NewRowsAffectedResult(3))
+	StubExecAutoIncrement("insert into logs (msg) values (?)", 10)
+
+	var buf bytes.Buffer
+	if err := Export(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	Reset()
+
+	if err := Import(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	var id int64
+	if err := db.QueryRow("select id from users").Scan(&id); err != nil || id != 1 {
+		t.Fatalf("expected the imported query stub to return 1, got %d and %v", id, err)
+	}
+
+	if _, err := db.Exec("select id from users"); err == nil || err.Error() != "not a statement" {
+		t.Fatalf("expected the imported exec error, got %v", err)
+	}
+
+	res, err := db.Exec("insert into users (name) values (?)", "tim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID, _ := res.LastInsertId(); lastID != 5 {
+		t.Fatalf("expected LastInsertId 5, got %d", lastID)
+	}
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		t.Fatalf("expected 1 row affected, got %d", affected)
+	}
+
+	res, err = db.Exec("delete from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := res.LastInsertId(); err != ErrLastInsertIdUnset {
+		t.Fatalf("expected ErrLastInsertIdUnset, got %v", err)
+	}
+	if affected, _ := res.RowsAffected(); affected != 3 {
+		t.Fatalf("expected 3 rows affected, got %d", affected)
+	}
+
+	for _, expected := range []int64{10, 11} {
+		res, err := db.Exec("insert into logs (msg) values (?)", "hi")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastID, _ := res.LastInsertId(); lastID != expected {
+			t.Fatalf("expected LastInsertId %d, got %d", expected, lastID)
+		}
+	}
+}
+
+func TestExportExecFunc(t *testing.T) {
+	defer Reset()
+
+	StubExecFunc("update users set name = ?", func(args []driver.Value) (driver.Result, error) {
+		return NewRowsAffectedResult(1), nil
+	})
+
+	if err := Export(&bytes.Buffer{}); err == nil {
+		t.Fatal("expected exporting a StubExecFunc() stub to fail")
+	}
+}
+
+func TestExportImportTimeZones(t *testing.T) {
+	defer Reset()
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	times := []time.Time{
+		time.Date(2012, 10, 1, 1, 0, 1, 500, time.UTC),
+		time.Date(2012, 10, 1, 1, 0, 1, 0, newYork),
+		time.Date(2012, 10, 1, 1, 0, 1, 0, time.FixedZone("AEST", 10*60*60)),
+	}
+
+	query := "select created from events"
+	cases := []struct {
+		name  string
+		opts  []ExportOption
+		zoned bool
+	}{
+		{"default", nil, true},
+		{"layout", []ExportOption{WithTimeLayout("2006-01-02 15:04:05.999999999")}, true},
+		{"utc", []ExportOption{WithoutTimeZone()}, false},
+	}
+
+	for _, tc := range cases {
+		Reset()
+
+		b := NewRows("created")
+		for _, tm := range times {
+			b.AddRow(tm)
+		}
+		StubQuery(query, b.Build())
+
+		var buf bytes.Buffer
+		if err := Export(&buf, tc.opts...); err != nil {
+			t.Fatal(err)
+		}
+
+		Reset()
+
+		if err := Import(&buf, tc.opts...); err != nil {
+			t.Fatalf("%s: %s", tc.name, err)
+		}
+
+		r, _, _ := GetStub(query)
+		imported := r.(*rows).rows
+		for i, tm := range times {
+			got := imported[i][0].(time.Time)
+			if !got.Equal(tm) {
+				t.Fatalf("%s: expected %s, got %s", tc.name, tm, got)
+			}
+
+			zone := "UTC"
+			if tc.zoned {
+				zone = tm.Location().String()
+			}
+			if got.Location().String() != zone {
+				t.Fatalf("%s: expected %s to be in %s", tc.name, got, zone)
+			}
+		}
+	}
+}
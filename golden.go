@@ -0,0 +1,49 @@
+package testdb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Set to a non empty value to have GoldenQueries() rewrite the golden files instead of comparing against them.
+const UpdateGoldenEnv = "TESTDB_UPDATE_GOLDEN"
+
+// Compares every call received by the global driver.Conn so far, with its arguments, against the golden file at path, one call per line. The file is written when it doesn't exist yet, or when the UpdateGoldenEnv environment variable is set, so the SQL a test emits can be recorded once and checked on every run after. Call it at the end of the test, before Reset().
+func GoldenQueries(t testing.TB, path string) {
+	t.Helper()
+
+	calls := Calls()
+	actual := make([]string, len(calls))
+	for i, c := range calls {
+		actual[i] = c.String()
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) || os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("testdb: %s", err)
+		}
+
+		content := strings.Join(actual, "\n")
+		if len(actual) > 0 {
+			content += "\n"
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("testdb: %s", err)
+		}
+		return
+	} else if err != nil {
+		t.Fatalf("testdb: %s", err)
+	}
+
+	var expected []string
+	if s := strings.TrimRight(string(b), "\n"); s != "" {
+		expected = strings.Split(s, "\n")
+	}
+
+	if strings.Join(expected, "\n") != strings.Join(actual, "\n") {
+		t.Errorf("testdb: queries don't match %s, set %s=1 to update it\n%s", path, UpdateGoldenEnv, describeQueryOrder(expected, actual))
+	}
+}
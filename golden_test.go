@@ -0,0 +1,58 @@
+package testdb
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoldenQueries(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	path := filepath.Join(t.TempDir(), "testdata", "users.golden")
+
+	run := func(name string) {
+		Reset()
+		SetMissingStubBehavior(MissingStubEmptyRows)
+		db.Query("select id from users where name = ?", name)
+		db.Exec("delete from sessions")
+	}
+
+	run("tim")
+	GoldenQueries(t, path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "select id from users where name = ? [$1=\"tim\"]\ndelete from sessions []\n" {
+		t.Fatalf("unexpected golden file:\n%s", b)
+	}
+
+	run("tim")
+	ft := &fakeTB{}
+	GoldenQueries(ft, path)
+	if ft.failed {
+		t.Fatalf("the same queries should match the golden file: %v", ft.msgs)
+	}
+
+	run("joe")
+	ft = &fakeTB{}
+	GoldenQueries(ft, path)
+	if !ft.failed {
+		t.Fatal("different arguments should fail")
+	}
+
+	t.Setenv(UpdateGoldenEnv, "1")
+	GoldenQueries(t, path)
+
+	os.Unsetenv(UpdateGoldenEnv)
+	ft = &fakeTB{}
+	GoldenQueries(ft, path)
+	if ft.failed {
+		t.Fatal("the updated golden file should match")
+	}
+}
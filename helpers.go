@@ -0,0 +1,213 @@
+package testdb
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Reads every row from r into plain Go values. It works with any driver.Rows, not just ones created by this package, and closes r once done.
+func DumpRows(r driver.Rows) (columns []string, data [][]driver.Value, err error) {
+	defer r.Close()
+
+	columns = r.Columns()
+	data = [][]driver.Value{}
+
+	for {
+		dest := make([]driver.Value, len(columns))
+		if err := r.Next(dest); err == io.EOF {
+			break
+		} else if err != nil {
+			return columns, data, err
+		}
+		data = append(data, dest)
+	}
+
+	if e, ok := r.(interface{ Err() error }); ok {
+		err = e.Err()
+	}
+
+	return columns, data, err
+}
+
+// Reads r to the end, discarding the values, and closes it. Returns the first error Next() returns other than io.EOF, or else the error of Err() if r has one, or else the error of Close(), so a result can be checked to iterate cleanly without caring what it holds.
+func Drain(r driver.Rows) error {
+	dest := make([]driver.Value, len(r.Columns()))
+
+	var err error
+	for {
+		if err = r.Next(dest); err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+
+	if e, ok := r.(interface{ Err() error }); ok && err == nil {
+		err = e.Err()
+	}
+
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Reads every remaining row of r, such as the result of a query against a real database, into a driver.Rows that can be stubbed and queried again and again. The column names and the scan type, nullability, database type name and decimal size database/sql reports for them are kept, the values are the ones the driver returned. r is closed once done, and the error of r.Err() is returned if reading it failed.
+func RowsFromSQLRows(r *sql.Rows) (driver.Rows, error) {
+	defer r.Close()
+
+	types, err := r.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	defs := make([]ColumnDef, len(types))
+	for i, ct := range types {
+		defs[i] = ColumnDef{Name: ct.Name(), ScanType: ct.ScanType(), DBTypeName: ct.DatabaseTypeName()}
+		if nullable, ok := ct.Nullable(); ok {
+			defs[i].Nullable = nullable
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok {
+			defs[i].Precision, defs[i].Scale = precision, scale
+		}
+	}
+
+	data := [][]driver.Value{}
+	for r.Next() {
+		values := make([]interface{}, len(defs))
+		dest := make([]interface{}, len(defs))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := r.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		row := make([]driver.Value, len(values))
+		for i, v := range values {
+			row[i] = v
+		}
+		data = append(data, row)
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewTypedRows(defs, data), nil
+}
+
+// Returns r as a *sql.Rows, so a driver.Rows can be checked with Scan() and ColumnTypes(). The rows come from a throwaway sql.DB that doesn't share anything with the global driver.Conn, and are closed along with it when the test finishes.
+func AsSQLRows(t testing.TB, r driver.Rows) *sql.Rows {
+	t.Helper()
+
+	c := newConn()
+	c.queryFunc = func(context.Context, string, []driver.Value) (driver.Rows, error) {
+		return r, nil
+	}
+
+	db := sql.OpenDB(connConnector{c})
+	rows, err := db.Query("select")
+	if err != nil {
+		db.Close()
+		t.Fatalf("testdb: %s", err)
+	}
+
+	t.Cleanup(func() {
+		rows.Close()
+		db.Close()
+	})
+
+	return rows
+}
+
+// Opens a sql.DB on the global driver.Conn for a strict test. Once the test finishes it fails if any stub was never used or any query didn't match a stub, then closes the db and calls Reset().
+func BindStrict(t testing.TB) (*sql.DB, driver.Conn) {
+	t.Helper()
+
+	db, err := sql.Open("testdb", "")
+	if err != nil {
+		t.Fatalf("testdb: %s", err)
+	}
+
+	t.Cleanup(func() {
+		AssertAllStubsUsed(t)
+		AssertNoUnexpectedQueries(t)
+		db.Close()
+		Reset()
+	})
+
+	return db, Conn()
+}
+
+// Hands out the same conn every time, without going through the global driver.
+type connConnector struct {
+	c driver.Conn
+}
+
+func (cc connConnector) Connect(context.Context) (driver.Conn, error) {
+	return cc.c, nil
+}
+
+func (connConnector) Driver() driver.Driver {
+	return d
+}
+
+// Reads both a and b and reports whether they hold the same columns and values, along with a description of the first difference when they don't. Values are compared after converting them to driver.Value, so an int and an int64 holding the same number are equal, and times are equal when they are the same instant. Both rows are closed.
+func RowsEqual(a, b driver.Rows) (bool, string) {
+	aColumns, aData, err := DumpRows(a)
+	if err != nil {
+		return false, "reading first rows: " + err.Error()
+	}
+
+	bColumns, bData, err := DumpRows(b)
+	if err != nil {
+		return false, "reading second rows: " + err.Error()
+	}
+
+	if !reflect.DeepEqual(aColumns, bColumns) {
+		return false, fmt.Sprintf("columns differ: %q != %q", aColumns, bColumns)
+	}
+
+	for i := 0; i < len(aData) && i < len(bData); i++ {
+		for j := range aColumns {
+			if !valuesEqual(aData[i][j], bData[i][j]) {
+				return false, fmt.Sprintf("row %d column %q differs: %s != %s", i, aColumns[j], defaultArgFormat(aData[i][j]), defaultArgFormat(bData[i][j]))
+			}
+		}
+	}
+
+	if len(aData) != len(bData) {
+		return false, fmt.Sprintf("row counts differ: %d != %d", len(aData), len(bData))
+	}
+
+	return true, ""
+}
+
+func valuesEqual(a, b driver.Value) bool {
+	if av, err := driver.DefaultParameterConverter.ConvertValue(a); err == nil {
+		a = av
+	}
+	if bv, err := driver.DefaultParameterConverter.ConvertValue(b); err == nil {
+		b = bv
+	}
+
+	switch av := a.(type) {
+	case time.Time:
+		bt, ok := b.(time.Time)
+		return ok && av.Equal(bt)
+	case []byte:
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(av, bb)
+	}
+
+	return reflect.DeepEqual(a, b)
+}
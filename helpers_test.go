@@ -0,0 +1,188 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// failingRows is a driver.Rows that isn't built by this package, it returns an error after its rows.
+type failingRows struct {
+	data [][]driver.Value
+	pos  int
+	err  error
+}
+
+func (r *failingRows) Columns() []string { return []string{"id"} }
+func (r *failingRows) Close() error      { return nil }
+func (r *failingRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.data[r.pos])
+	r.pos++
+	return nil
+}
+
+func TestDumpRows(t *testing.T) {
+	columns, data, err := DumpRows(RowsFromCSVString([]string{"id", "name"}, "1,tim\n2,joe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(columns, []string{"id", "name"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+
+	expected := [][]driver.Value{{"1", "tim"}, {"2", "joe"}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Fatalf("expected %v, got %v", expected, data)
+	}
+}
+
+func TestDumpRowsAnyDriverRows(t *testing.T) {
+	r := &failingRows{data: [][]driver.Value{{int64(1)}}, err: errors.New("connection lost")}
+
+	_, data, err := DumpRows(r)
+
+	if err == nil || err.Error() != "connection lost" {
+		t.Fatal("DumpRows should return the error from Next")
+	}
+
+	if len(data) != 1 || data[0][0] != int64(1) {
+		t.Fatal("DumpRows should return the rows read before the error")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	data := [][]driver.Value{{int64(1)}, {int64(2)}}
+
+	if err := Drain(RowsFromSlice([]string{"id"}, data)); err != nil {
+		t.Fatalf("a clean result should drain without an error, got %v", err)
+	}
+
+	streamErr := errors.New("connection reset")
+	if err := Drain(RowsWithResultAndError([]string{"id"}, data, streamErr)); err != streamErr {
+		t.Fatalf("expected the error returned mid-stream, got %v", err)
+	}
+
+	closeErr := errors.New("close failed")
+	if err := Drain(RowsWithCloseError([]string{"id"}, data, closeErr)); err != closeErr {
+		t.Fatalf("expected the error returned by Close, got %v", err)
+	}
+}
+
+func TestRowsFromSQLRows(t *testing.T) {
+	defer Reset()
+
+	defs := []ColumnDef{
+		{Name: "id", ScanType: reflect.TypeOf(int64(0)), DBTypeName: "BIGINT"},
+		{Name: "name", ScanType: reflect.TypeOf(""), Nullable: true, DBTypeName: "TEXT"},
+		{Name: "price", ScanType: reflect.TypeOf(""), DBTypeName: "NUMERIC", Precision: 10, Scale: 2},
+	}
+	data := [][]driver.Value{{int64(1), "tim", "9.99"}, {int64(2), nil, "19.90"}}
+
+	frozen, err := RowsFromSQLRows(AsSQLRows(t, NewTypedRows(defs, data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(frozen.(*rows).defs, defs) {
+		t.Fatalf("expected the column types to be kept, got %+v", frozen.(*rows).defs)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, got, err := DumpRows(cloneRows(frozen)); err != nil || !reflect.DeepEqual(got, data) {
+			t.Fatalf("expected %v, got %v %v", data, got, err)
+		}
+	}
+
+	streamErr := errors.New("connection reset")
+	if _, err := RowsFromSQLRows(AsSQLRows(t, RowsWithResultAndError([]string{"id"}, [][]driver.Value{{int64(1)}}, streamErr))); err != streamErr {
+		t.Fatalf("expected the error reading the rows, got %v", err)
+	}
+}
+
+func TestAsSQLRows(t *testing.T) {
+	defer Reset()
+
+	rows := AsSQLRows(t, NewTypedRows([]ColumnDef{
+		{Name: "id", ScanType: reflect.TypeOf(int64(0)), DBTypeName: "BIGINT"},
+		{Name: "name", ScanType: reflect.TypeOf(""), DBTypeName: "TEXT"},
+	}, [][]driver.Value{{int64(1), "tim"}, {int64(2), "joe"}}))
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types[1].DatabaseTypeName() != "TEXT" {
+		t.Fatal("column types should come from the rows")
+	}
+
+	var names []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"tim", "joe"}) {
+		t.Fatalf("unexpected rows %v", names)
+	}
+
+	if len(Calls()) != 0 {
+		t.Fatal("the global driver.Conn should not be used")
+	}
+}
+
+func TestRowsEqual(t *testing.T) {
+	born := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		a, b  driver.Rows
+		equal bool
+		diff  string
+	}{
+		{
+			"equal after normalization",
+			RowsFromSlice([]string{"id", "score", "born", "data"}, [][]driver.Value{{1, float32(1.5), born, []byte("x")}}),
+			RowsFromSlice([]string{"id", "score", "born", "data"}, [][]driver.Value{{int64(1), 1.5, born.In(time.FixedZone("CEST", 7200)), []byte("x")}}),
+			true, "",
+		},
+		{
+			"different columns",
+			RowsFromSlice([]string{"id", "name"}, nil),
+			RowsFromSlice([]string{"id", "email"}, nil),
+			false, `columns differ: ["id" "name"] != ["id" "email"]`,
+		},
+		{
+			"different cell",
+			RowsFromSlice([]string{"id", "name"}, [][]driver.Value{{int64(1), "tim"}, {int64(2), "joe"}}),
+			RowsFromSlice([]string{"id", "name"}, [][]driver.Value{{int64(1), "tim"}, {int64(2), "bob"}}),
+			false, `row 1 column "name" differs: "joe" != "bob"`,
+		},
+		{
+			"different row counts",
+			RowsFromSlice([]string{"id"}, [][]driver.Value{{int64(1)}}),
+			RowsFromSlice([]string{"id"}, [][]driver.Value{{int64(1)}, {int64(2)}}),
+			false, "row counts differ: 1 != 2",
+		},
+	}
+
+	for _, test := range tests {
+		equal, diff := RowsEqual(test.a, test.b)
+		if equal != test.equal || diff != test.diff {
+			t.Errorf("%s: expected %v %q, got %v %q", test.name, test.equal, test.diff, equal, diff)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package testdb
+
+import "database/sql/driver"
+
+// The driver interfaces each type is meant to satisfy, so dropping a method breaks the build instead of silently changing how database/sql talks to the driver.
+var (
+	_ driver.Driver    = (*testDriver)(nil)
+	_ driver.Connector = connector{}
+	_ driver.Connector = connConnector{}
+
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.Queryer            = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.Execer             = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.Conn               = (*sessionConn)(nil)
+	_ driver.QueryerContext     = (*sessionConn)(nil)
+	_ driver.ExecerContext      = (*sessionConn)(nil)
+
+	_ driver.Stmt             = (*stmt)(nil)
+	_ driver.StmtQueryContext = (*stmt)(nil)
+	_ driver.StmtExecContext  = (*stmt)(nil)
+
+	_ driver.Tx     = (*Tx)(nil)
+	_ driver.Result = (*Result)(nil)
+
+	_ driver.Rows                           = (*rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*rows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*rows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*rows)(nil)
+	_ driver.Rows                           = (*generatedRows)(nil)
+	_ driver.Rows                           = (*renamedRows)(nil)
+	_ driver.RowsNextResultSet              = (*multiRows)(nil)
+	_ driver.RowsNextResultSet              = (*countingRows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*countingRows)(nil)
+	_ driver.RowsColumnTypeNullable         = (*countingRows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*countingRows)(nil)
+	_ driver.RowsColumnTypeLength           = (*countingRows)(nil)
+	_ driver.RowsColumnTypePrecisionScale   = (*countingRows)(nil)
+)
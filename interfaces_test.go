@@ -0,0 +1,175 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestDriverPaths(t *testing.T) {
+	defer Reset()
+
+	ctx := context.Background()
+	db := sql.OpenDB(Connector())
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	query := "select id from users"
+	StubQuery(query, NewTypedRows([]ColumnDef{
+		{Name: "id", ScanType: reflect.TypeOf(int64(0)), DBTypeName: "BIGINT"},
+	}, [][]driver.Value{{int64(1)}}))
+	StubExec("delete from users", NewResult(0, nil, 1, nil))
+
+	scan := func(r *sql.Row) {
+		t.Helper()
+		var id int64
+		if err := r.Scan(&id); err != nil || id != 1 {
+			t.Fatalf("unexpected result %d %v", id, err)
+		}
+	}
+
+	// QueryerContext and ExecerContext
+	scan(db.QueryRowContext(ctx, query))
+	if _, err := db.ExecContext(ctx, "delete from users"); err != nil {
+		t.Fatal(err)
+	}
+
+	// ConnPrepareContext, StmtQueryContext and StmtExecContext
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan(stmt.QueryRowContext(ctx))
+	stmt.Close()
+
+	stmt, _ = db.PrepareContext(ctx, "delete from users")
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+
+	// Tx
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan(tx.QueryRow(query))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ = db.Begin()
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	// RowsColumnTypeScanType and RowsColumnTypeDatabaseTypeName
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, _ := rows.ColumnTypes()
+	rows.Close()
+	if types[0].ScanType() != reflect.TypeOf(int64(0)) || types[0].DatabaseTypeName() != "BIGINT" {
+		t.Fatal("column types should come from the rows")
+	}
+
+	if DirectQueryCount() != 3 || PreparedQueryCount() != 1 {
+		t.Fatalf("unexpected query counts %d direct, %d prepared", DirectQueryCount(), PreparedQueryCount())
+	}
+}
+
+// fullRows is a driver.Rows that isn't built by this package and implements every optional Rows interface.
+type fullRows struct {
+	sets [][]driver.Value
+	set  int
+	pos  int
+}
+
+func (r *fullRows) Columns() []string { return []string{"price"} }
+func (r *fullRows) Close() error      { return nil }
+
+func (r *fullRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.sets[r.set]) {
+		return io.EOF
+	}
+	dest[0] = r.sets[r.set][r.pos]
+	r.pos++
+	return nil
+}
+
+func (r *fullRows) ColumnTypeScanType(int) reflect.Type               { return reflect.TypeOf("") }
+func (r *fullRows) ColumnTypeNullable(int) (bool, bool)               { return true, true }
+func (r *fullRows) ColumnTypeDatabaseTypeName(int) string             { return "NUMERIC" }
+func (r *fullRows) ColumnTypeLength(int) (int64, bool)                { return 12, true }
+func (r *fullRows) ColumnTypePrecisionScale(int) (int64, int64, bool) { return 10, 2, true }
+func (r *fullRows) HasNextResultSet() bool                            { return r.set+1 < len(r.sets) }
+
+func (r *fullRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set, r.pos = r.set+1, 0
+	return nil
+}
+
+func TestOptionalRowsInterfaces(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select price from products"
+	StubQuery(query, &fullRows{sets: [][]driver.Value{{"9.99"}, {"19.90"}}})
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct := types[0]
+
+	if ct.ScanType() != reflect.TypeOf("") {
+		t.Fatalf("unexpected scan type %v", ct.ScanType())
+	}
+	if nullable, ok := ct.Nullable(); !ok || !nullable {
+		t.Fatal("nullability should be passed through")
+	}
+	if ct.DatabaseTypeName() != "NUMERIC" {
+		t.Fatalf("unexpected database type name %q", ct.DatabaseTypeName())
+	}
+	if length, ok := ct.Length(); !ok || length != 12 {
+		t.Fatalf("expected a length of 12, got %d %v", length, ok)
+	}
+	if precision, scale, ok := ct.DecimalSize(); !ok || precision != 10 || scale != 2 {
+		t.Fatalf("expected NUMERIC(10, 2), got %d %d %v", precision, scale, ok)
+	}
+
+	var prices []string
+	for {
+		for rows.Next() {
+			var price string
+			if err := rows.Scan(&price); err != nil {
+				t.Fatal(err)
+			}
+			prices = append(prices, price)
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(prices, []string{"9.99", "19.90"}) {
+		t.Fatalf("expected both result sets, got %v", prices)
+	}
+}
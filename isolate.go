@@ -0,0 +1,123 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"sync"
+)
+
+var (
+	dsnMu         sync.Mutex
+	isolatePerDSN bool
+	dsnConns      = make(map[string]*conn)
+	// Connections created by SetConnFactory() and NewConn(), for ResetAll()
+	trackedConns = make(map[*conn]bool)
+)
+
+// When set to true, db.Open() hands back a separate driver.Conn for every non empty DSN, so parallel tests opening distinct DSNs don't share stubs. Those connections are stubbed through ForDSN(), the package level functions and the empty DSN keep using the global driver.Conn. Reset() doesn't clear isolated connections, use ForDSN(dsn).Reset() instead.
+func IsolatePerDSN(flag bool) {
+	dsnMu.Lock()
+	isolatePerDSN = flag
+	dsnMu.Unlock()
+}
+
+// The stubs of the driver.Conn isolated for a single DSN, see IsolatePerDSN(), or for a single connection, see SetConnFactory().
+type DSNConn struct {
+	conn *conn
+}
+
+// Returns the driver.Conn handed out for dsn when IsolatePerDSN(true) is set, creating it if the DSN hasn't been opened yet.
+func ForDSN(dsn string) *DSNConn {
+	return &DSNConn{conn: dsnConn(dsn)}
+}
+
+func dsnConn(dsn string) *conn {
+	dsnMu.Lock()
+	defer dsnMu.Unlock()
+
+	c, ok := dsnConns[dsn]
+	if !ok {
+		c = newConn()
+		dsnConns[dsn] = c
+	}
+	return c
+}
+
+func track(c *conn) *conn {
+	dsnMu.Lock()
+	trackedConns[c] = true
+	dsnMu.Unlock()
+
+	return c
+}
+
+func isolated(dsn string) (*conn, bool) {
+	dsnMu.Lock()
+	flag := isolatePerDSN
+	dsnMu.Unlock()
+
+	if !flag || dsn == "" {
+		return nil, false
+	}
+	return dsnConn(dsn), true
+}
+
+// Makes every db.Open() hand back a connection with stubs of its own instead of the global driver.Conn, seeded by calling f with it. Each physical connection in the pool then has independent state, so sessions can see different data, and calls to the connection are only recorded on it. DSNs isolated with IsolatePerDSN() are unaffected. Reset() removes the factory, connections already open keep their stubs until ResetAll() is called.
+func SetConnFactory(f func(c *DSNConn)) {
+	d.connFactory = f
+}
+
+// Same as Reset(), but also clears the stubs, counters and logs of every connection isolated by IsolatePerDSN(), created for SetConnFactory() or created with NewConn(), for suites that share the driver across groups of tests, from TestMain for example. Whether connections are isolated is left as it is.
+func ResetAll() {
+	Reset()
+
+	dsnMu.Lock()
+	defer dsnMu.Unlock()
+
+	for _, c := range dsnConns {
+		c.reset()
+	}
+	for c := range trackedConns {
+		c.reset()
+	}
+}
+
+// Same as StubQuery(), for this DSN only.
+func (c *DSNConn) StubQuery(q string, rows driver.Rows) {
+	mustStub(c.conn.stub(q, query{
+		rows: rows,
+	}))
+}
+
+// Same as StubQueryError(), for this DSN only.
+func (c *DSNConn) StubQueryError(q string, err error) {
+	mustStub(c.conn.stub(q, query{
+		err: err,
+	}))
+}
+
+// Same as StubExec(), for this DSN only.
+func (c *DSNConn) StubExec(q string, r *Result) {
+	mustStub(c.conn.stubExec(q, query{
+		result: r,
+	}))
+}
+
+// Same as StubExecError(), for this DSN only.
+func (c *DSNConn) StubExecError(q string, err error) {
+	mustStub(c.conn.stubExec(q, query{
+		err: err,
+	}))
+}
+
+// Same as Calls(), for this DSN only.
+func (c *DSNConn) Calls() []Call {
+	c.conn.mu.Lock()
+	defer c.conn.mu.Unlock()
+
+	return append([]Call(nil), c.conn.calls...)
+}
+
+// Clears the stubs and calls of this DSN only.
+func (c *DSNConn) Reset() {
+	c.conn.reset()
+}
@@ -0,0 +1,165 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestIsolatePerDSN(t *testing.T) {
+	IsolatePerDSN(true)
+
+	t.Run("group", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			dsn := fmt.Sprintf("isolated-%d", i)
+			name := fmt.Sprintf("user-%d", i)
+
+			t.Run(dsn, func(t *testing.T) {
+				t.Parallel()
+
+				c := ForDSN(dsn)
+				defer c.Reset()
+
+				c.StubQuery("select name from users", RowsFromCSVString([]string{"name"}, name))
+
+				db, _ := sql.Open("testdb", dsn)
+				defer db.Close()
+
+				for j := 0; j < 10; j++ {
+					var got string
+					if err := db.QueryRow("select name from users").Scan(&got); err != nil {
+						t.Fatal(err)
+					}
+					if got != name {
+						t.Fatalf("expected %s, got %s", name, got)
+					}
+				}
+
+				if n := len(c.Calls()); n != 10 {
+					t.Fatalf("expected 10 calls on %s, got %d", dsn, n)
+				}
+			})
+		}
+	})
+
+	IsolatePerDSN(false)
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "isolated-0")
+	if _, err := db.Query("select name from users"); err == nil {
+		t.Fatal("the global conn should be used once isolation is turned off")
+	}
+}
+
+func TestResetAll(t *testing.T) {
+	defer IsolatePerDSN(false)
+	IsolatePerDSN(true)
+
+	name := RegisterUnique()
+	global, _ := sql.Open(name, "")
+	isolated, _ := sql.Open(name, "reset-all")
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+	ForDSN("reset-all").StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "joe"))
+
+	for _, db := range []*sql.DB{global, isolated} {
+		if err := db.QueryRow("select name from users").Scan(new(string)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Opened after the global connection is pooled, so only this sql.DB gets a connection from the factory
+	defer Reset()
+	SetConnFactory(func(c *DSNConn) {
+		c.StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "bob"))
+	})
+	factory, _ := sql.Open(name, "")
+	built := OpenConn(NewConn(WithQueryStub("select name from users", RowsFromCSVString([]string{"name"}, "ann"))))
+
+	for db, expected := range map[*sql.DB]string{factory: "bob", built: "ann"} {
+		var got string
+		if err := db.QueryRow("select name from users").Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Fatalf("expected %s, got %s", expected, got)
+		}
+	}
+
+	ResetAll()
+
+	for _, db := range []*sql.DB{global, isolated, factory, built} {
+		if err := db.QueryRow("select name from users").Scan(new(string)); err == nil {
+			t.Fatal("stubs should be cleared from every connection")
+		}
+	}
+
+	ResetAll()
+
+	if len(Calls()) != 0 || len(ForDSN("reset-all").Calls()) != 0 {
+		t.Fatal("calls should be cleared from every connection")
+	}
+}
+
+func TestSetConnFactory(t *testing.T) {
+	defer Reset()
+
+	var mu sync.Mutex
+	sessions := 0
+	SetConnFactory(func(c *DSNConn) {
+		mu.Lock()
+		sessions++
+		name := fmt.Sprintf("session-%d", sessions)
+		mu.Unlock()
+
+		c.StubQuery("select current_user", RowsFromCSVString([]string{"user"}, name))
+	})
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	ctx := context.Background()
+	first, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	var wg sync.WaitGroup
+	users := make([]string, 2)
+	for i, c := range []*sql.Conn{first, second} {
+		wg.Add(1)
+		go func(i int, c *sql.Conn) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				var user string
+				if err := c.QueryRowContext(ctx, "select current_user").Scan(&user); err != nil {
+					t.Error(err)
+					return
+				}
+				if j > 0 && user != users[i] {
+					t.Errorf("a session should keep seeing its own data, got %s then %s", users[i], user)
+					return
+				}
+				users[i] = user
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	if users[0] == users[1] || users[0] == "" {
+		t.Fatalf("each session should see its own data, got %v", users)
+	}
+
+	if len(Calls()) != 0 {
+		t.Fatal("calls to seeded connections shouldn't be recorded on the global conn")
+	}
+}
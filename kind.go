@@ -0,0 +1,31 @@
+package testdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// When set to true, running a SELECT with db.Exec(), or an INSERT, UPDATE or DELETE without a RETURNING clause with db.Query(), returns an error rather than the stubbed result. The kind of statement is taken from its leading keyword.
+func SetEnforceStatementKind(flag bool) {
+	d.conn.mu.Lock()
+	d.conn.enforceKind = flag
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) checkKind(query string, kind CallKind) error {
+	c.mu.Lock()
+	enforce := c.enforceKind
+	c.mu.Unlock()
+
+	if !enforce {
+		return nil
+	}
+
+	switch verb := leadingVerb(query); {
+	case kind == CallExec && verb == "select":
+		return fmt.Errorf("testdb: SELECT statement run with Exec, use Query instead: %s", query)
+	case kind == CallQuery && (verb == "insert" || verb == "update" || verb == "delete") && returningColumns(query) == nil:
+		return fmt.Errorf("testdb: %s statement without RETURNING run with Query, use Exec instead: %s", strings.ToUpper(verb), query)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package testdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSetEnforceStatementKind(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetEnforceStatementKind(true)
+	SetMissingStubBehavior(MissingStubEmptyRows)
+
+	if _, err := db.Exec("select id from users"); err == nil || !strings.Contains(err.Error(), "SELECT statement run with Exec") {
+		t.Fatalf("expected an error running a SELECT with Exec, got %v", err)
+	}
+
+	for _, query := range []string{
+		"insert into users (name) values ('tim')",
+		"UPDATE users SET name = 'tim'",
+		"/* cleanup */ delete from users",
+	} {
+		if _, err := db.Query(query); err == nil || !strings.Contains(err.Error(), "without RETURNING run with Query") {
+			t.Fatalf("expected an error running %q with Query, got %v", query, err)
+		}
+	}
+
+	if _, err := db.Query("insert into users (name) values ('tim') returning id"); err != nil {
+		t.Fatal("statements with RETURNING should be allowed with Query")
+	}
+	if _, err := db.Query("select id from users"); err != nil {
+		t.Fatal("SELECT should be allowed with Query")
+	}
+	if _, err := db.Exec("delete from users"); err != nil {
+		t.Fatal("DELETE should be allowed with Exec")
+	}
+
+	SetEnforceStatementKind(false)
+	if _, err := db.Exec("select id from users"); err != nil {
+		t.Fatal("statement kinds should not be checked by default")
+	}
+}
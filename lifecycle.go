@@ -0,0 +1,29 @@
+package testdb
+
+// Returns the number of times database/sql has closed a prepared statement of the global driver.Conn since the last Reset(). This includes the statements database/sql prepares and closes by itself, such as those for db.Exec() when SetExecerEnabled(false) is set.
+func StmtCloseCount() int {
+	return d.conn.closeCount(&d.conn.stmtCloses)
+}
+
+// Returns the number of times database/sql has closed the rows of a query run on the global driver.Conn since the last Reset(). Comparing it with the number of queries run catches rows that are never closed.
+func RowsCloseCount() int {
+	return d.conn.closeCount(&d.conn.rowsCloses)
+}
+
+// Returns the number of times database/sql has closed the global driver.Conn, or one of the connections sharing it handed out with SetNewConnPerOpen(true), since the last Reset().
+func ConnCloseCount() int {
+	return d.conn.closeCount(&d.conn.connCloses)
+}
+
+func (c *conn) countClose(n *int) {
+	c.mu.Lock()
+	*n++
+	c.mu.Unlock()
+}
+
+func (c *conn) closeCount(n *int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return *n
+}
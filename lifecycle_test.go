@@ -0,0 +1,71 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestCloseCounts(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		rows, err := stmt.Query(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	if RowsCloseCount() != 2 || StmtCloseCount() != 0 || ConnCloseCount() != 0 {
+		t.Fatalf("expected only the rows to be closed, got %d rows %d stmts %d conns", RowsCloseCount(), StmtCloseCount(), ConnCloseCount())
+	}
+
+	stmt.Close()
+	if StmtCloseCount() != 1 {
+		t.Fatalf("expected 1 statement close, got %d", StmtCloseCount())
+	}
+
+	db.Close()
+	if ConnCloseCount() != 1 {
+		t.Fatalf("expected 1 connection close, got %d", ConnCloseCount())
+	}
+
+	if RowsCloseCount() != 2 || StmtCloseCount() != 1 {
+		t.Fatal("closing the connection shouldn't change the other counts")
+	}
+
+	Reset()
+	if RowsCloseCount() != 0 || StmtCloseCount() != 0 || ConnCloseCount() != 0 {
+		t.Fatal("Reset should clear the counts")
+	}
+}
+
+func TestPrepareNotStubbed(t *testing.T) {
+	defer Reset()
+
+	stmt, err := Conn().Prepare("select name from users")
+	if err == nil {
+		t.Fatal("preparing a query that isn't stubbed should fail")
+	}
+	if stmt != nil {
+		t.Fatalf("a failed prepare shouldn't return a statement, got %#v", stmt)
+	}
+
+	db, _ := sql.Open("testdb", "")
+	if _, err := db.Prepare("select name from users"); err == nil {
+		t.Fatal("preparing a query that isn't stubbed should fail")
+	}
+	if StmtCloseCount() != 0 {
+		t.Fatalf("a failed prepare shouldn't count as a statement close, got %d", StmtCloseCount())
+	}
+}
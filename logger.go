@@ -0,0 +1,41 @@
+package testdb
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func (c *conn) logf(format string, args ...interface{}) {
+	c.mu.Lock()
+	w := c.logger
+	c.mu.Unlock()
+
+	if w != nil {
+		fmt.Fprintf(w, "testdb: "+format+"\n", args...)
+	}
+}
+
+// Writes a line to w for every prepare, query, exec, begin, commit and rollback on the global driver.Conn. Pass nil to stop logging.
+func SetLogger(w io.Writer) {
+	d.conn.mu.Lock()
+	d.conn.logger = w
+	d.conn.mu.Unlock()
+}
+
+// Logs every call received by the global driver.Conn through t.Log, so it is only shown with -v or when the test fails. Reset() stops logging.
+func SetTestLogger(t testing.TB) {
+	SetLogger(testLogWriter{t})
+}
+
+type testLogWriter struct {
+	t testing.TB
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+
+	return len(p), nil
+}
@@ -0,0 +1,78 @@
+package testdb
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestSetLogger(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	var buf bytes.Buffer
+	SetLogger(&buf)
+
+	StubQuery("select name from users where id = ?", RowsFromCSVString([]string{"name"}, "tim"))
+	StubExec("delete from users", NewResult(0, nil, 1, nil))
+
+	stmt, err := db.Prepare("select name from users where id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	stmt.QueryRow(1).Scan(&name)
+	stmt.Close()
+
+	tx, _ := db.Begin()
+	tx.Exec("delete from users")
+	tx.Commit()
+
+	expected := []string{
+		"testdb: prepare select name from users where id = ?",
+		"testdb: query select name from users where id = ? [$1=1]",
+		"testdb: begin",
+		"testdb: exec delete from users []",
+		"testdb: commit",
+	}
+
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected log:\n%s", buf.String())
+	}
+}
+
+type logTB struct {
+	testing.TB
+	logs []string
+}
+
+func (l *logTB) Helper() {}
+
+func (l *logTB) Log(args ...interface{}) {
+	for _, a := range args {
+		l.logs = append(l.logs, a.(string))
+	}
+}
+
+func TestSetTestLogger(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	lt := &logTB{}
+	SetTestLogger(lt)
+	db.Exec("delete from users")
+
+	if len(lt.logs) != 2 || lt.logs[0] != "testdb: exec delete from users []" || lt.logs[1] != "testdb: Exec call not stubbed: delete from users\n\tnormalized: delete from users" {
+		t.Fatalf("unexpected test log: %v", lt.logs)
+	}
+
+	Reset()
+	db.Exec("delete from users")
+
+	if len(lt.logs) != 2 {
+		t.Fatal("Reset should stop logging")
+	}
+}
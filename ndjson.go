@@ -0,0 +1,139 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type ndjsonRows struct {
+	columns []string
+	dec     *json.Decoder
+	first   map[string]interface{}
+	line    int
+}
+
+// Returns a driver.Rows reading one JSON object per line from r, each object is only decoded when Next() reaches it so large fixtures are never held in memory. The values of the supplied columns are taken from every object by key, or when columns is nil the keys of the first object become the columns, in the order they appear. Keys missing from an object and JSON nulls are NULL, whole numbers are int64, other numbers float64, and strings and booleans keep their Go types. Objects and arrays are returned as their JSON text in a []byte. Returns an error if the first object can't be read to infer the columns, later malformed lines make Next() return an error.
+func RowsFromNDJSON(r io.Reader, columns []string) (driver.Rows, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	rows := &ndjsonRows{columns: columns, dec: dec}
+	if columns != nil {
+		return rows, nil
+	}
+
+	keys, obj, err := rows.readObject()
+	if err == io.EOF {
+		return nil, fmt.Errorf("testdb: can't infer the columns of an empty NDJSON fixture")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows.columns = keys
+	rows.first = obj
+
+	return rows, nil
+}
+
+func (r *ndjsonRows) Columns() []string {
+	return r.columns
+}
+
+func (r *ndjsonRows) Close() error {
+	return nil
+}
+
+func (r *ndjsonRows) Next(dest []driver.Value) error {
+	obj := r.first
+	r.first = nil
+
+	if obj == nil {
+		var err error
+		if _, obj, err = r.readObject(); err != nil {
+			return err
+		}
+	}
+
+	for i, col := range r.columns {
+		val, err := ndjsonValue(obj[col])
+		if err != nil {
+			return fmt.Errorf("testdb: NDJSON line %d column %q: %s", r.line, col, err)
+		}
+		dest[i] = val
+	}
+
+	return nil
+}
+
+// Decodes the next object, returning its keys in the order they appear.
+func (r *ndjsonRows) readObject() ([]string, map[string]interface{}, error) {
+	if !r.dec.More() {
+		return nil, nil, io.EOF
+	}
+	r.line++
+
+	tok, err := r.dec.Token()
+	if err != nil {
+		return nil, nil, fmt.Errorf("testdb: NDJSON line %d: %s", r.line, err)
+	}
+	if tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("testdb: NDJSON line %d: expected an object, got %v", r.line, tok)
+	}
+
+	var keys []string
+	obj := make(map[string]interface{})
+	for r.dec.More() {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, nil, fmt.Errorf("testdb: NDJSON line %d: %s", r.line, err)
+		}
+		key := tok.(string)
+
+		var val json.RawMessage
+		if err := r.dec.Decode(&val); err != nil {
+			return nil, nil, fmt.Errorf("testdb: NDJSON line %d key %q: %s", r.line, key, err)
+		}
+
+		if _, ok := obj[key]; !ok {
+			keys = append(keys, key)
+		}
+		obj[key] = val
+	}
+
+	if _, err := r.dec.Token(); err != nil {
+		return nil, nil, fmt.Errorf("testdb: NDJSON line %d: %s", r.line, err)
+	}
+
+	return keys, obj, nil
+}
+
+func ndjsonValue(v interface{}) (driver.Value, error) {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, nil
+	}
+
+	switch raw[0] {
+	case '{', '[':
+		return []byte(raw), nil
+	case 'n':
+		return nil, nil
+	case '"':
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	case 't', 'f':
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return b, err
+	}
+
+	n := json.Number(raw)
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	return n.Float64()
+}
@@ -0,0 +1,80 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRowsFromNDJSON(t *testing.T) {
+	fixture := `{"id": 1, "name": "tim", "score": 1.5, "active": true}
+{"id": 2, "name": null, "tags": ["a", "b"]}
+
+{"name": "joe", "id": 3, "active": false}
+`
+
+	r, err := RowsFromNDJSON(strings.NewReader(fixture), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name", "score", "active"}) {
+		t.Fatalf("columns should come from the first line, got %v", r.Columns())
+	}
+
+	expected := [][]driver.Value{
+		{int64(1), "tim", 1.5, true},
+		{int64(2), nil, nil, nil},
+		{int64(3), "joe", nil, false},
+	}
+
+	dest := make([]driver.Value, 4)
+	for i, row := range expected {
+		if err := r.Next(dest); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(dest, row) {
+			t.Fatalf("row %d: expected %v, got %v", i, row, dest)
+		}
+	}
+
+	if err := r.Next(dest); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last line, got %v", err)
+	}
+}
+
+func TestRowsFromNDJSONColumns(t *testing.T) {
+	r, err := RowsFromNDJSON(strings.NewReader(`{"id": 1, "tags": ["a", "b"]}`), []string{"tags", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := make([]driver.Value, 2)
+	if err := r.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dest, []driver.Value{[]byte(`["a", "b"]`), nil}) {
+		t.Fatalf("unexpected values %q", dest)
+	}
+}
+
+func TestRowsFromNDJSONLazy(t *testing.T) {
+	r, err := RowsFromNDJSON(strings.NewReader("{\"id\": 1}\nnot json\n"), nil)
+	if err != nil {
+		t.Fatalf("lines after the first shouldn't be read up front, got %v", err)
+	}
+
+	dest := make([]driver.Value, 1)
+	if err := r.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Next(dest); err == nil || err == io.EOF {
+		t.Fatalf("a malformed line should return an error, got %v", err)
+	}
+
+	if _, err := RowsFromNDJSON(strings.NewReader(""), nil); err == nil {
+		t.Fatal("columns can't be inferred from an empty fixture")
+	}
+}
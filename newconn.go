@@ -0,0 +1,86 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+)
+
+// Configures a connection created with NewConn().
+type ConnOption func(*connBuilder)
+
+type connBuilder struct {
+	c     *conn
+	stubs []func(c *conn) error
+}
+
+// Returns a driver.Conn with stubs of its own, configured by opts in one expression, independent of the global driver.Conn the package level functions work on. Options that change how queries are matched are applied before any stub whatever their order. Open a sql.DB on it with OpenConn(). Panics if a stub can't be stored, as StubQuery() does. ResetAll() clears its stubs along with those of every other connection.
+func NewConn(opts ...ConnOption) driver.Conn {
+	b := &connBuilder{c: track(newConn())}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	for _, stub := range b.stubs {
+		mustStub(stub(b.c))
+	}
+
+	return b.c
+}
+
+// Returns a sql.DB whose every connection is c, such as one created with NewConn(), without going through the driver registry.
+func OpenConn(c driver.Conn) *sql.DB {
+	return sql.OpenDB(connConnector{c})
+}
+
+// Stubs the query to return the supplied driver.Rows, as StubQuery() does.
+func WithQueryStub(q string, rows driver.Rows) ConnOption {
+	return func(b *connBuilder) {
+		b.stubs = append(b.stubs, func(c *conn) error {
+			return c.stub(q, query{rows: rows})
+		})
+	}
+}
+
+// Stubs the query to return the supplied error, as StubQueryError() does.
+func WithQueryStubError(q string, err error) ConnOption {
+	return func(b *connBuilder) {
+		b.stubs = append(b.stubs, func(c *conn) error {
+			return c.stub(q, query{err: err})
+		})
+	}
+}
+
+// Stubs the query to return the supplied Result from db.Exec(), as StubExec() does.
+func WithExecStub(q string, r *Result) ConnOption {
+	return func(b *connBuilder) {
+		b.stubs = append(b.stubs, func(c *conn) error {
+			return c.stubExec(q, query{result: r})
+		})
+	}
+}
+
+// Calls f for every query, as SetQueryWithArgsFunc() does.
+func WithQueryFunc(f func(query string, args []driver.Value) (driver.Rows, error)) ConnOption {
+	return func(b *connBuilder) {
+		b.c.queryFunc = func(_ context.Context, query string, args []driver.Value) (driver.Rows, error) {
+			return f(query, args)
+		}
+	}
+}
+
+// Sets what happens to queries that haven't been stubbed, as SetMissingStubBehavior() does.
+func WithMissingStubBehavior(behavior MissingStubBehavior) ConnOption {
+	return func(b *connBuilder) {
+		b.c.missingStubBehavior = behavior
+	}
+}
+
+// Matches queries on the text returned by f instead of their normalized text, so queries f maps to the same string share a stub.
+func WithNormalizer(f func(query string) string) ConnOption {
+	return func(b *connBuilder) {
+		b.c.fingerprint = func(query string) (string, error) {
+			return f(query), nil
+		}
+	}
+}
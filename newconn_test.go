@@ -0,0 +1,64 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewConn(t *testing.T) {
+	defer Reset()
+
+	stubErr := errors.New("stubbed error")
+	db := OpenConn(NewConn(
+		WithQueryStub("select id from USERS", RowsFromCSVString([]string{"id"}, "1")),
+		WithQueryStubError("select id from admins", stubErr),
+		WithExecStub("delete from users", NewResult(0, nil, 3, nil)),
+		WithMissingStubBehavior(MissingStubEmptyRows),
+		WithNormalizer(strings.ToLower),
+	))
+	defer db.Close()
+
+	var id int64
+	if err := db.QueryRow("SELECT ID FROM users").Scan(&id); err != nil || id != 1 {
+		t.Fatalf("the stub should be matched through the normalizer, got %d %v", id, err)
+	}
+
+	if _, err := db.Query("select id from admins"); !errors.Is(err, stubErr) {
+		t.Fatalf("expected the stubbed error, got %v", err)
+	}
+
+	res, err := db.Exec("delete from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Fatalf("expected 3 rows affected, got %d", n)
+	}
+
+	rows, err := db.Query("select * from missing")
+	if err != nil {
+		t.Fatalf("unstubbed queries should return empty rows, got %v", err)
+	}
+	if rows.Next() {
+		t.Fatal("expected no rows")
+	}
+	rows.Close()
+
+	if len(Calls()) != 0 {
+		t.Fatal("queries against a NewConn shouldn't reach the global conn")
+	}
+}
+
+func TestNewConnQueryFunc(t *testing.T) {
+	db := OpenConn(NewConn(WithQueryFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		return RowsFromSlice([]string{"arg"}, [][]driver.Value{{args[0]}}), nil
+	})))
+	defer db.Close()
+
+	var name string
+	if err := db.QueryRow("select name from users where id = ?", "tim").Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("expected the query func to be called, got %q %v", name, err)
+	}
+}
@@ -0,0 +1,440 @@
+package testdb
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenSpace tokenKind = iota
+	tokenComment
+	tokenWord
+	tokenQuotedIdent
+	tokenString
+	tokenNumber
+	tokenPlaceholder
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// Splits a query into tokens, keeping string literals, quoted identifiers and comments intact so they are never mistaken for keywords.
+func tokenize(query string) []token {
+	var tokens []token
+	r := []rune(query)
+
+	for i := 0; i < len(r); {
+		start := i
+		kind := tokenPunct
+
+		switch c := r[i]; {
+		case unicode.IsSpace(c):
+			kind = tokenSpace
+			for i < len(r) && unicode.IsSpace(r[i]) {
+				i++
+			}
+		case c == '-' && i+1 < len(r) && r[i+1] == '-':
+			kind = tokenComment
+			for i < len(r) && r[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(r) && r[i+1] == '*':
+			kind = tokenComment
+			i += 2
+			for i < len(r) && !(r[i] == '*' && i+1 < len(r) && r[i+1] == '/') {
+				i++
+			}
+			i = min(i+2, len(r))
+		case c == '\'':
+			kind = tokenString
+			i = skipQuoted(r, i, '\'')
+		case c == '"' || c == '`':
+			kind = tokenQuotedIdent
+			i = skipQuoted(r, i, c)
+		case unicode.IsDigit(c) || (c == '.' && i+1 < len(r) && unicode.IsDigit(r[i+1])):
+			kind = tokenNumber
+			for i < len(r) && (unicode.IsDigit(r[i]) || r[i] == '.') {
+				i++
+			}
+			if i < len(r) && (r[i] == 'e' || r[i] == 'E') {
+				i++
+				if i < len(r) && (r[i] == '+' || r[i] == '-') {
+					i++
+				}
+				for i < len(r) && unicode.IsDigit(r[i]) {
+					i++
+				}
+			}
+		case isWordStart(c):
+			kind = tokenWord
+			for i < len(r) && isWordPart(r[i]) {
+				i++
+			}
+		case c == '?':
+			kind = tokenPlaceholder
+			i++
+		case c == '$' && i+1 < len(r) && unicode.IsDigit(r[i+1]):
+			kind = tokenPlaceholder
+			i++
+			for i < len(r) && unicode.IsDigit(r[i]) {
+				i++
+			}
+		case (c == ':' || c == '@') && i+1 < len(r) && isWordStart(r[i+1]) && (i == 0 || r[i-1] != ':'):
+			kind = tokenPlaceholder
+			i++
+			for i < len(r) && isWordPart(r[i]) {
+				i++
+			}
+		default:
+			i++
+		}
+
+		tokens = append(tokens, token{kind: kind, text: string(r[start:i])})
+	}
+
+	return tokens
+}
+
+// Returns the index just past the closing quote, a doubled quote is treated as an escaped quote.
+func skipQuoted(r []rune, i int, quote rune) int {
+	i++
+	for i < len(r) {
+		if r[i] == quote {
+			if i+1 < len(r) && r[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		}
+		i++
+	}
+	return i
+}
+
+func isWordStart(c rune) bool {
+	return unicode.IsLetter(c) || c == '_'
+}
+
+func isWordPart(c rune) bool {
+	return isWordStart(c) || unicode.IsDigit(c) || c == '$'
+}
+
+func joinTokens(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+func (t token) is(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+// Returns the first keyword of the query in lower case, skipping leading whitespace and comments.
+func leadingVerb(query string) string {
+	for _, t := range tokenize(query) {
+		switch t.kind {
+		case tokenSpace, tokenComment:
+			continue
+		case tokenWord:
+			return strings.ToLower(t.text)
+		}
+		return ""
+	}
+	return ""
+}
+
+// Reports whether the query holds nothing but whitespace and comments.
+func isEmptyQuery(query string) bool {
+	for _, t := range tokenize(query) {
+		if t.kind != tokenSpace && t.kind != tokenComment {
+			return false
+		}
+	}
+	return true
+}
+
+// Applies the optional normalization modes configured on the conn before a query is hashed.
+func (c *conn) normalize(query string) string {
+	if len(c.ignoredClauses) == 0 && !c.matchInlinedLiterals && !c.collapseInLists && !c.ignorePaging && !c.stripSchemas {
+		return query
+	}
+
+	tokens := tokenize(query)
+	if len(c.ignoredClauses) > 0 {
+		tokens = stripTrailingClauses(tokens, c.ignoredClauses)
+	}
+	if c.matchInlinedLiterals {
+		tokens = replaceLiterals(tokens)
+	}
+	if c.collapseInLists {
+		tokens = collapseInLists(tokens)
+	}
+	if c.ignorePaging {
+		tokens = replacePagingValues(tokens)
+	}
+	if c.stripSchemas {
+		tokens = stripSchemaQualifiers(tokens)
+	}
+
+	return joinTokens(tokens)
+}
+
+// Turns every number, string literal and placeholder into a "?" placeholder, identifiers and quoted identifiers are left alone.
+func replaceLiterals(tokens []token) []token {
+	replaced := make([]token, len(tokens))
+	for i, t := range tokens {
+		switch t.kind {
+		case tokenNumber, tokenString, tokenPlaceholder:
+			t = token{kind: tokenPlaceholder, text: "?"}
+		}
+		replaced[i] = t
+	}
+	return replaced
+}
+
+// Replaces every "IN (" followed only by placeholders, commas and whitespace up to the closing parenthesis with "IN (?)".
+func collapseInLists(tokens []token) []token {
+	var collapsed []token
+	for i := 0; i < len(tokens); i++ {
+		collapsed = append(collapsed, tokens[i])
+		if !tokens[i].is("in") {
+			continue
+		}
+
+		open := nextToken(tokens, i+1)
+		if open >= len(tokens) || tokens[open].text != "(" {
+			continue
+		}
+
+		end, placeholders := open+1, 0
+		for ; end < len(tokens); end++ {
+			t := tokens[end]
+			if t.kind == tokenPlaceholder {
+				placeholders++
+			} else if t.kind != tokenSpace && t.text != "," {
+				break
+			}
+		}
+
+		if end < len(tokens) && tokens[end].text == ")" && placeholders > 0 {
+			collapsed = append(collapsed, token{kind: tokenSpace, text: " "}, token{kind: tokenPunct, text: "("}, token{kind: tokenPlaceholder, text: "?"}, tokens[end])
+			i = end
+		}
+	}
+	return collapsed
+}
+
+// Turns the numbers given to LIMIT, OFFSET and FETCH FIRST or NEXT into "?" placeholders, including both numbers of MySQL's "LIMIT 20, 10". Numbers anywhere else and string literals are left alone.
+func replacePagingValues(tokens []token) []token {
+	replaced := append([]token(nil), tokens...)
+	placeholder := token{kind: tokenPlaceholder, text: "?"}
+
+	for i, t := range replaced {
+		if !t.is("limit") && !t.is("offset") && !t.is("first") && !t.is("next") {
+			continue
+		}
+
+		n := nextToken(replaced, i+1)
+		if n >= len(replaced) || replaced[n].kind != tokenNumber {
+			continue
+		}
+		replaced[n] = placeholder
+
+		if !t.is("limit") {
+			continue
+		}
+		comma := nextToken(replaced, n+1)
+		if comma < len(replaced) && replaced[comma].text == "," {
+			if m := nextToken(replaced, comma+1); m < len(replaced) && replaced[m].kind == tokenNumber {
+				replaced[m] = placeholder
+			}
+		}
+	}
+	return replaced
+}
+
+// Keywords that a table name follows.
+var tableKeywords = map[string]bool{"from": true, "join": true, "into": true, "update": true, "table": true}
+
+// Keywords that end a list of tables, so a comma after them doesn't separate table names.
+var clauseKeywords = map[string]bool{"select": true, "where": true, "on": true, "using": true, "set": true, "values": true, "group": true, "order": true, "having": true, "limit": true, "returning": true, "union": true}
+
+// Keeps only the last part of every dotted name in a table position, so "public.users" becomes "users".
+func stripSchemaQualifiers(tokens []token) []token {
+	var stripped []token
+	var prev token
+	inTables := false
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+
+		tablePosition := prev.kind == tokenWord && tableKeywords[strings.ToLower(prev.text)] || prev.text == "," && inTables
+		if tablePosition && isIdentifier(t) {
+			for next := nextToken(tokens, i+1); next+1 < len(tokens) && tokens[next].text == "."; next = nextToken(tokens, i+1) {
+				part := nextToken(tokens, next+1)
+				if part >= len(tokens) || !isIdentifier(tokens[part]) {
+					break
+				}
+				i, t = part, tokens[part]
+			}
+		}
+
+		if t.kind == tokenWord {
+			switch word := strings.ToLower(t.text); {
+			case tableKeywords[word]:
+				inTables = true
+			case clauseKeywords[word]:
+				inTables = false
+			}
+		}
+
+		stripped = append(stripped, t)
+		if t.kind != tokenSpace && t.kind != tokenComment {
+			prev = t
+		}
+	}
+	return stripped
+}
+
+func isIdentifier(t token) bool {
+	return t.kind == tokenWord || t.kind == tokenQuotedIdent
+}
+
+// Returns the index of the first token from i on that isn't whitespace or a comment.
+func nextToken(tokens []token, i int) int {
+	for i < len(tokens) && (tokens[i].kind == tokenSpace || tokens[i].kind == tokenComment) {
+		i++
+	}
+	return i
+}
+
+func (c *conn) hash(query string) string {
+	if c.fingerprint != nil {
+		if fp, err := c.fingerprint(query); err == nil {
+			// Prefixed so a fingerprint can never collide with a normalized query
+			return hashString("fingerprint:" + fp)
+		}
+	}
+
+	return getQueryHash(c.normalize(query), c.caseSensitive)
+}
+
+// Returns the form of the query stubs are matched on, the one hashed by hash().
+func (c *conn) normalized(query string) string {
+	return compact(tokenize(c.normalize(query)), !c.caseSensitive)
+}
+
+// Joins the tokens without whitespace, keeping a single space only where it separates two words so "select a" and "selecta" still differ. When fold is set everything but string literals and quoted identifiers is lowercased.
+func compact(tokens []token, fold bool) string {
+	var b strings.Builder
+	var prev *token
+
+	for i := range tokens {
+		t := &tokens[i]
+		if t.kind == tokenSpace {
+			continue
+		}
+
+		if prev != nil && isWordLike(*prev) && isWordLike(*t) {
+			b.WriteByte(' ')
+		}
+
+		switch {
+		case t.kind == tokenString || t.kind == tokenQuotedIdent:
+			b.WriteString(t.text)
+		case t.kind == tokenComment:
+			b.WriteString(strings.Join(strings.Fields(t.text), ""))
+		case fold:
+			b.WriteString(strings.ToLower(t.text))
+		default:
+			b.WriteString(t.text)
+		}
+
+		prev = t
+	}
+
+	return b.String()
+}
+
+func isWordLike(t token) bool {
+	return t.kind == tokenWord || t.kind == tokenNumber || t.kind == tokenPlaceholder
+}
+
+// Keywords that start a clause which is never stripped, an ignored clause followed by one of these isn't trailing.
+var clauseStoppers = []string{"select", "from", "where", "group", "having", "union", "intersect", "except", "for", "returning", "window", "fetch", "into"}
+
+// Repeatedly removes the last top level ignored clause while it only runs to the end of the query, so "ORDER BY a LIMIT 10" loses both clauses but a LIMIT inside a subquery is left alone.
+func stripTrailingClauses(tokens []token, clauses [][]string) []token {
+	for {
+		end := len(tokens)
+		for end > 0 && (tokens[end-1].kind == tokenSpace || tokens[end-1].kind == tokenComment || tokens[end-1].text == ";") {
+			end--
+		}
+		tokens = tokens[:end]
+
+		start := lastClauseStart(tokens, clauses)
+		if start < 0 {
+			return tokens
+		}
+
+		tokens = tokens[:start]
+	}
+}
+
+func lastClauseStart(tokens []token, clauses [][]string) int {
+	depth := 0
+	last := -1
+
+	for i, t := range tokens {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.kind == tokenWord:
+			if matchesClause(tokens[i:], clauses) {
+				last = i
+			} else if last >= 0 {
+				for _, s := range clauseStoppers {
+					if t.is(s) {
+						last = -1
+						break
+					}
+				}
+			}
+		}
+	}
+
+	if depth != 0 {
+		return -1
+	}
+	return last
+}
+
+func matchesClause(tokens []token, clauses [][]string) bool {
+	for _, clause := range clauses {
+		i := 0
+		matched := true
+		for _, word := range clause {
+			for i < len(tokens) && (tokens[i].kind == tokenSpace || tokens[i].kind == tokenComment) {
+				i++
+			}
+			if i >= len(tokens) || !tokens[i].is(word) {
+				matched = false
+				break
+			}
+			i++
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package testdb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreClauses(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	IgnoreClauses("ORDER BY", "LIMIT", "OFFSET")
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	queries := []string{
+		"select name from users order by name",
+		"SELECT name FROM users ORDER BY name DESC",
+		"select name from users limit 10",
+		"select name from users limit ? offset ?",
+		"select name from users offset 20;",
+		"select name from users order by created, name limit 5 offset 10",
+	}
+
+	for _, q := range queries {
+		var name string
+		if err := db.QueryRow(q, 1, 2).Scan(&name); err != nil {
+			t.Fatalf("%s: %s", q, err)
+		}
+
+		if name != "tim" {
+			t.Fatalf("%s: unexpected result %s", q, name)
+		}
+	}
+}
+
+func TestIgnoreClausesOnlyTrailing(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	IgnoreClauses("ORDER BY", "LIMIT")
+
+	StubQuery("select name from users where note = 'order by name'", RowsFromCSVString([]string{"name"}, "tim"))
+	StubQuery("select name from (select name from users limit 1) u", RowsFromCSVString([]string{"name"}, "joe"))
+
+	unmatched := []string{
+		"select name from users where note = ''",
+		"select name from (select name from users) u",
+		"select name from users limit 1 for update",
+	}
+
+	for _, q := range unmatched {
+		if _, err := db.Query(q); err == nil {
+			t.Fatalf("%s: should not match a stub", q)
+		}
+	}
+
+	var name string
+	if err := db.QueryRow("select name from users where note = 'order by name' order by name").Scan(&name); err != nil || name != "tim" {
+		t.Fatal("clause inside a string literal should be kept")
+	}
+
+	if err := db.QueryRow("select name from (select name from users limit 1) u limit 5").Scan(&name); err != nil || name != "joe" {
+		t.Fatal("limit inside a subquery should be kept")
+	}
+}
+
+func TestSetCaseSensitive(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetCaseSensitive(true)
+
+	StubQuery(`select "Name" from users`, RowsFromCSVString([]string{"Name"}, "upper"))
+	StubQuery(`select "name" from users`, RowsFromCSVString([]string{"name"}, "lower"))
+
+	var name string
+	if err := db.QueryRow(`select   "Name"
+		from users`).Scan(&name); err != nil || name != "upper" {
+		t.Fatal("case sensitive stubs should not collide")
+	}
+
+	if err := db.QueryRow(`select "name" from users`).Scan(&name); err != nil || name != "lower" {
+		t.Fatal("case sensitive stubs should not collide")
+	}
+
+	if _, err := db.Query(`SELECT "name" FROM users`); err == nil {
+		t.Fatal("keyword case should matter when case sensitive")
+	}
+}
+
+func TestDefaultNormalization(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"SELECT name FROM users", "select name from users", true},
+		{"select Name from Users", "select name from users", true},
+		{"select name   from\n\tusers", "select name from users", true},
+		{"select count( * ) from users", "select count(*) from users", true},
+		{"select name from users where name = 'Tim'", "SELECT name FROM users WHERE name = 'Tim'", true},
+		{"select name from users where name = 'Tim'", "select name from users where name = 'tim'", false},
+		{"select name from users where name = 'a b'", "select name from users where name = 'ab'", false},
+		{`select "Name" from users`, `SELECT "Name" FROM users`, true},
+		{`select "Name" from users`, `select "name" from users`, false},
+		{"select a from users", "selecta from users", false},
+		{"select name from users where note = 'it''s'", "select name from users where note = 'It''s'", false},
+	}
+
+	c := newConn()
+	for _, tc := range cases {
+		if collide := c.hash(tc.a) == c.hash(tc.b); collide != tc.collide {
+			t.Errorf("%q and %q: expected collide=%v", tc.a, tc.b, tc.collide)
+		}
+	}
+}
+
+func TestLiteralCaseMatters(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select id from users where name = 'Tim'", RowsFromCSVString([]string{"id"}, "1"))
+	StubQuery("select id from users where name = 'tim'", RowsFromCSVString([]string{"id"}, "2"))
+
+	var id int64
+	if err := db.QueryRow("SELECT id FROM users WHERE name = 'Tim'").Scan(&id); err != nil || id != 1 {
+		t.Fatal("keyword case should be ignored while literal case is kept")
+	}
+
+	if err := db.QueryRow("select ID from USERS where NAME = 'tim'").Scan(&id); err != nil || id != 2 {
+		t.Fatal("keyword case should be ignored while literal case is kept")
+	}
+}
+
+func TestSetMatchInlinedLiterals(t *testing.T) {
+	defer Reset()
+
+	SetMatchInlinedLiterals(true)
+
+	cases := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"select * from users where id = ?", "select * from users where id = 5", true},
+		{"select * from users where id = $1 and name = $2", "select * from users where id = 5 and name = 'tim'", true},
+		{"select * from users where price > ?", "select * from users where price > 1.5e3", true},
+		{"select * from users where id = ?", "select * from users where id = id5", false},
+		{`select * from users where "5" = ?`, "select * from users where ? = ?", false},
+		{"select * from t1 where id = ?", "select * from t2 where id = ?", false},
+	}
+
+	for _, tc := range cases {
+		if collide := d.conn.hash(tc.a) == d.conn.hash(tc.b); collide != tc.collide {
+			t.Errorf("%q and %q: expected collide=%v", tc.a, tc.b, tc.collide)
+		}
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select name from users where id = ? and role = ?", RowsFromCSVString([]string{"name"}, "tim"))
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users WHERE id = 5 AND role = 'admin'").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "tim" {
+		t.Fatal("the inlined query should match the parameterized stub")
+	}
+}
+
+func TestSetCollapseInLists(t *testing.T) {
+	defer Reset()
+
+	SetCollapseInLists(true)
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select name from users where id in (?)", RowsFromCSVString([]string{"name"}, "tim"))
+
+	for _, n := range []int{1, 3, 10} {
+		placeholders := make([]string, n)
+		args := make([]interface{}, n)
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+			args[i] = i
+		}
+
+		var name string
+		query := "SELECT name FROM users WHERE id IN (" + strings.Join(placeholders, ", ") + ")"
+		if err := db.QueryRow(query, args...).Scan(&name); err != nil || name != "tim" {
+			t.Fatalf("an IN list of %d placeholders should match the stub, got %q %v", n, name, err)
+		}
+	}
+
+	cases := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"select * from users where id in (?, ?) and role in (?)", "select * from users where id in (?) and role in (?, ?, ?)", true},
+		{"select * from users where id in (1, 2)", "select * from users where id in (1)", false},
+		{"select * from users where id in (?, 2)", "select * from users where id in (?)", false},
+		{"select * from users where id in (select id from admins)", "select * from users where id in (?)", false},
+	}
+
+	for _, tc := range cases {
+		if collide := d.conn.hash(tc.a) == d.conn.hash(tc.b); collide != tc.collide {
+			t.Errorf("%q and %q: expected collide=%v", tc.a, tc.b, tc.collide)
+		}
+	}
+}
+
+func TestSetIgnorePagingValues(t *testing.T) {
+	defer Reset()
+
+	SetIgnorePagingValues(true)
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select name from users order by id limit 10 offset 0", RowsFromCSVString([]string{"name"}, "tim"))
+
+	for page := 0; page < 3; page++ {
+		var name string
+		query := fmt.Sprintf("SELECT name FROM users ORDER BY id LIMIT 10 OFFSET %d", page*10)
+		if err := db.QueryRow(query).Scan(&name); err != nil || name != "tim" {
+			t.Fatalf("page %d should match the stub, got %q %v", page, name, err)
+		}
+	}
+
+	cases := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"select * from users limit 10 offset 20", "select * from users limit ? offset ?", true},
+		{"select * from users limit 20, 10", "select * from users limit 40, 25", true},
+		{"select * from users offset 20 rows fetch next 10 rows only", "select * from users offset 30 rows fetch next 5 rows only", true},
+		{"select * from users where age > 10 limit 10", "select * from users where age > 20 limit 10", false},
+		{"select * from users where note = 'limit 10'", "select * from users where note = 'limit 20'", false},
+	}
+
+	for _, tc := range cases {
+		if collide := d.conn.hash(tc.a) == d.conn.hash(tc.b); collide != tc.collide {
+			t.Errorf("%q and %q: expected collide=%v", tc.a, tc.b, tc.collide)
+		}
+	}
+}
+
+func TestNotStubbedDiagnostics(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select id, name from users where active = true", RowsFromCSVString([]string{"id", "name"}, "1,tim"))
+	StubQuery("select count(*) from orders", RowsFromCSVString([]string{"count"}, "1"))
+
+	_, err := db.Query("SELECT id, name FROM users WHERE active = 1")
+	if err == nil {
+		t.Fatal("the query should not match")
+	}
+
+	expected := "Query not stubbed: SELECT id, name FROM users WHERE active = 1" +
+		"\n\tnormalized: select id,name from users where active=1" +
+		"\n\tclosest stub: select id, name from users where active = true" +
+		"\n\tnormalized:   select id,name from users where active=true"
+	if err.Error() != expected {
+		t.Fatalf("unexpected error:\n%s", err)
+	}
+
+	_, err = db.Query("delete from sessions where expires < now()")
+	if strings.Contains(err.Error(), "closest stub") {
+		t.Fatalf("unrelated stubs should not be suggested:\n%s", err)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	cases := []struct {
+		a, b string
+		dist int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"select", "selcet", 2},
+	}
+
+	for _, tc := range cases {
+		if dist := levenshtein(tc.a, tc.b); dist != tc.dist {
+			t.Errorf("%q and %q: expected %d, got %d", tc.a, tc.b, tc.dist, dist)
+		}
+	}
+}
+
+func TestSetFingerprintFunc(t *testing.T) {
+	defer Reset()
+
+	// Fingerprints a query by the table it selects from, and can't parse anything else
+	SetFingerprintFunc(func(query string) (string, error) {
+		fields := strings.Fields(strings.ToLower(query))
+		for i, f := range fields {
+			if f == "from" && i+1 < len(fields) {
+				return "select:" + fields[i+1], nil
+			}
+		}
+		return "", errors.New("can't parse " + query)
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+	StubQuery("show tables", RowsFromCSVString([]string{"table"}, "users"))
+
+	var id int64
+	if err := db.QueryRow("select * from users").Scan(&id); err != nil || id != 1 {
+		t.Fatal("queries with the same fingerprint should match")
+	}
+
+	if _, err := db.Query("select id from orders"); err == nil {
+		t.Fatal("queries with different fingerprints should not match")
+	}
+
+	var table string
+	if err := db.QueryRow("SHOW  TABLES").Scan(&table); err != nil || table != "users" {
+		t.Fatal("queries the func can't fingerprint should fall back to the text match")
+	}
+}
+
+func TestStripSchemaQualifiers(t *testing.T) {
+	defer Reset()
+
+	StripSchemaQualifiers(true)
+
+	cases := []struct {
+		a, b    string
+		collide bool
+	}{
+		{"select * from users", "SELECT * FROM public.users", true},
+		{"select * from users u join orders o on o.user_id = u.id", `select * from public.users u join "sales".orders o on o.user_id = u.id`, true},
+		{"select * from users, orders", "select * from app.public.users, public.orders", true},
+		{"insert into users (name) values ('tim')", "insert into public.users (name) values ('tim')", true},
+		{"update users set name = 'tim'", "update public.users set name = 'tim'", true},
+		{"select users.id from users", "select id from users", false},
+		{"select * from users where name = 'public.users'", "select * from users where name = 'users'", false},
+		{"select * from users where a = b, c", "select * from users where a = x.b, c", false},
+	}
+
+	for _, tc := range cases {
+		if collide := d.conn.hash(tc.a) == d.conn.hash(tc.b); collide != tc.collide {
+			t.Errorf("%q and %q: expected collide=%v", tc.a, tc.b, tc.collide)
+		}
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	var name string
+	if err := db.QueryRow("select name from public.users").Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("the qualified query should match the stub, got %q %v", name, err)
+	}
+}
@@ -0,0 +1,31 @@
+package testdb
+
+import "database/sql/driver"
+
+// Receives a call for every driver method invoked on the global driver.Conn, and on the transactions it begins.
+type Observer interface {
+	OnPrepare(query string)
+	OnQuery(query string, args []driver.Value)
+	OnExec(query string, args []driver.Value)
+	OnBegin()
+	OnCommit()
+	OnRollback()
+	OnClose()
+}
+
+// Sets the Observer notified of every driver method called on the global driver.Conn. Pass nil to stop observing, Reset() also removes it.
+func SetObserver(o Observer) {
+	d.conn.mu.Lock()
+	d.conn.observer = o
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) notify(f func(o Observer)) {
+	c.mu.Lock()
+	o := c.observer
+	c.mu.Unlock()
+
+	if o != nil {
+		f(o)
+	}
+}
@@ -0,0 +1,93 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (r *recordingObserver) OnPrepare(query string) {
+	r.events = append(r.events, "prepare "+query)
+}
+
+func (r *recordingObserver) OnQuery(query string, args []driver.Value) {
+	r.events = append(r.events, fmt.Sprintf("query %s %v", query, args))
+}
+
+func (r *recordingObserver) OnExec(query string, args []driver.Value) {
+	r.events = append(r.events, fmt.Sprintf("exec %s %v", query, args))
+}
+
+func (r *recordingObserver) OnBegin() {
+	r.events = append(r.events, "begin")
+}
+
+func (r *recordingObserver) OnCommit() {
+	r.events = append(r.events, "commit")
+}
+
+func (r *recordingObserver) OnRollback() {
+	r.events = append(r.events, "rollback")
+}
+
+func (r *recordingObserver) OnClose() {
+	r.events = append(r.events, "close")
+}
+
+func TestSetObserver(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	o := &recordingObserver{}
+	SetObserver(o)
+
+	StubQuery("select name from users where id = ?", RowsFromCSVString([]string{"name"}, "tim"))
+	StubExec("delete from users", NewResult(0, nil, 1, nil))
+
+	stmt, err := db.Prepare("select name from users where id = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	stmt.QueryRow(1).Scan(&name)
+	stmt.Close()
+
+	tx, _ := db.Begin()
+	tx.Exec("delete from users")
+	tx.Commit()
+
+	tx, _ = db.Begin()
+	tx.Rollback()
+
+	db.Close()
+
+	expected := []string{
+		"prepare select name from users where id = ?",
+		"query select name from users where id = ? [1]",
+		"begin",
+		"exec delete from users []",
+		"commit",
+		"begin",
+		"rollback",
+		"close",
+	}
+
+	if strings.Join(o.events, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected events:\n%s", strings.Join(o.events, "\n"))
+	}
+
+	n := len(o.events)
+	Reset()
+	db, _ = sql.Open("testdb", "")
+	db.Query("select 1")
+	if len(o.events) != n {
+		t.Fatal("Reset should remove the observer")
+	}
+}
@@ -0,0 +1,33 @@
+package testdb
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned for writes made inside a read-only transaction when SetEnforceReadOnlyTx(true) has been called, like the error a read replica reports.
+var ErrReadOnlyTransaction = errors.New("testdb: cannot execute a write in a read-only transaction")
+
+// Statements that change data or schema, none of them can run in a read-only transaction.
+var writeVerbs = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "upsert": true, "replace": true,
+	"create": true, "alter": true, "drop": true, "truncate": true, "grant": true, "revoke": true,
+}
+
+// When set to true, an INSERT, UPDATE, DELETE or other write made while a transaction begun with sql.TxOptions{ReadOnly: true} is open returns ErrReadOnlyTransaction, whether it runs with db.Exec() or db.Query(). As every db shares the global driver.Conn, a write made outside the read-only transaction while it is open is rejected too.
+func SetEnforceReadOnlyTx(flag bool) {
+	d.conn.mu.Lock()
+	d.conn.enforceReadOnlyTx = flag
+	d.conn.mu.Unlock()
+}
+
+func (c *conn) checkReadOnly(query string) error {
+	c.mu.Lock()
+	readOnly := c.enforceReadOnlyTx && c.readOnlyTxs > 0
+	c.mu.Unlock()
+
+	if readOnly && writeVerbs[leadingVerb(query)] {
+		return fmt.Errorf("%w: %s", ErrReadOnlyTransaction, query)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestSetEnforceReadOnlyTx(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetEnforceReadOnlyTx(true)
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+	StubExec("update users set active = false", NewRowsAffectedResult(1))
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.QueryRow("select id from users").Scan(new(int64)); err != nil {
+		t.Fatalf("reads should be allowed in a read-only transaction, got %v", err)
+	}
+
+	if _, err := tx.Exec("update users set active = false"); !errors.Is(err, ErrReadOnlyTransaction) {
+		t.Fatalf("expected ErrReadOnlyTransaction, got %v", err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("update users set active = false"); err != nil {
+		t.Fatalf("writes should be allowed once the read-only transaction is done, got %v", err)
+	}
+}
+
+func TestReadOnlyTxNotEnforced(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubExec("update users set active = false", NewRowsAffectedResult(1))
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("update users set active = false"); err != nil {
+		t.Fatalf("writes shouldn't be rejected unless enforcement is enabled, got %v", err)
+	}
+}
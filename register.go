@@ -0,0 +1,81 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.Mutex
+	registered = make(map[string]bool)
+	uniqueID   int
+)
+
+// Registers the testdb driver with database/sql under the supplied name. Registering a name more than once is a no-op, unlike sql.Register() which panics, but a name already used by another driver returns an error.
+func Register(name string) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registered[name] {
+		return nil
+	}
+
+	for _, existing := range sql.Drivers() {
+		if existing == name {
+			return errors.New("testdb: driver name already registered by another driver: " + name)
+		}
+	}
+
+	sql.Register(name, d)
+	registered[name] = true
+
+	return nil
+}
+
+// Registers the testdb driver under a name that hasn't been used yet in this process and returns it.
+func RegisterUnique() string {
+	for {
+		registryMu.Lock()
+		uniqueID++
+		name := fmt.Sprintf("testdb-%d", uniqueID)
+		registryMu.Unlock()
+
+		if err := Register(name); err == nil {
+			return name
+		}
+	}
+}
+
+// Returns every name the testdb driver has been registered under, sorted.
+func Drivers() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Returns a driver.Connector for the testdb driver, sql.OpenDB(testdb.Connector()) opens a database without going through the driver registry at all.
+func Connector() driver.Connector {
+	return connector{}
+}
+
+type connector struct{}
+
+func (connector) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (connector) Driver() driver.Driver {
+	return d
+}
@@ -0,0 +1,63 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	if err := Register("testdb-register"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Register("testdb-register"); err != nil {
+		t.Fatal("registering the same name twice should be a no-op")
+	}
+
+	if err := Register("testdb"); err != nil {
+		t.Fatal("registering the default name should be a no-op")
+	}
+
+	found := false
+	for _, name := range Drivers() {
+		if name == "testdb-register" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("registered name missing from Drivers(): %v", Drivers())
+	}
+}
+
+func TestRegisterUnique(t *testing.T) {
+	defer Reset()
+
+	a, b := RegisterUnique(), RegisterUnique()
+	if a == b {
+		t.Fatal("RegisterUnique should return a different name each time")
+	}
+
+	StubQuery("select 1", RowsFromCSVString([]string{"1"}, "1"))
+
+	db, err := sql.Open(a, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal("uniquely registered drivers should use the same stubs")
+	}
+}
+
+func TestConnector(t *testing.T) {
+	defer Reset()
+
+	db := sql.OpenDB(Connector())
+	defer db.Close()
+
+	StubQuery("select 1", RowsFromCSVString([]string{"1"}, "1"))
+
+	if _, err := db.Query("select 1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,5 +1,9 @@
 package testdb
 
+import "errors"
+
+var ErrLastInsertIdUnset = errors.New("testdb: LastInsertId was not stubbed")
+
 type Result struct {
 	lastInsertId      int64
 	lastInsertIdError error
@@ -16,6 +20,11 @@ func NewResult(lastId int64, lastIdError error, rowsAffected int64, rowsAffected
 	}
 }
 
+// Returns a Result for UPDATE or DELETE statements that reports the supplied number of affected rows. LastInsertId() returns ErrLastInsertIdUnset.
+func NewRowsAffectedResult(rowsAffected int64) *Result {
+	return NewResult(0, ErrLastInsertIdUnset, rowsAffected, nil)
+}
+
 func (res *Result) LastInsertId() (int64, error) {
 	return res.lastInsertId, res.lastInsertIdError
 }
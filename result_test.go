@@ -0,0 +1,110 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+)
+
+func TestNewRowsAffectedResult(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "UPDATE users SET active = false WHERE age > ? AND name <> ?"
+	StubExec(query, NewRowsAffectedResult(7))
+
+	res, err := db.Exec(query, 30, "tim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 7 {
+		t.Fatalf("expected 7 rows affected, got %d", n)
+	}
+
+	if _, err := res.LastInsertId(); err != ErrLastInsertIdUnset {
+		t.Fatal("LastInsertId should return an error when it wasn't stubbed")
+	}
+}
+
+// Marks every user in the batch as active, failing when any of them wasn't updated.
+func activateUsers(db *sql.DB, ids []int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("update users set active = true where id = ? and version = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var affected int64
+	for _, id := range ids {
+		res, err := stmt.Exec(id, 1)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		affected += n
+	}
+
+	if affected < int64(len(ids)) {
+		return fmt.Errorf("updated %d of %d users", affected, len(ids))
+	}
+	return tx.Commit()
+}
+
+func TestPartialWrite(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "update users set active = true where id = ? and version = ?"
+	StubExecFunc(query, func(args []driver.Value) (driver.Result, error) {
+		// User 2 was changed by someone else, so its version no longer matches
+		if args[0] == int64(2) {
+			return NewRowsAffectedResult(0), nil
+		}
+		return NewRowsAffectedResult(1), nil
+	})
+
+	committed := false
+	SetCommitFunc(func() error {
+		committed = true
+		return nil
+	})
+
+	if err := activateUsers(db, []int64{1, 2, 3}); err == nil || err.Error() != "updated 2 of 3 users" {
+		t.Fatalf("the shortfall should be detected, got %v", err)
+	}
+
+	if committed {
+		t.Fatal("a partial write shouldn't be committed")
+	}
+
+	batch := "update users set active = true where id in (?, ?, ?)"
+	StubExec(batch, NewRowsAffectedResult(2))
+
+	for i := 0; i < 2; i++ {
+		res, err := db.Exec(batch, 1, 2, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 2 {
+			t.Fatalf("expected 2 of the 3 rows to be affected, got %d %v", n, err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Stubs an INSERT, UPDATE or DELETE with a RETURNING clause to return a single row holding the supplied values. Statements with RETURNING are run with db.Query() or db.QueryRow() rather than db.Exec(), so this is the same as StubQuery() with the row's columns taken from the RETURNING clause. Panics if the clause doesn't name one column per value.
+func StubReturning(q string, values ...driver.Value) {
+	columns := returningColumns(q)
+	if len(columns) != len(values) {
+		panic(fmt.Sprintf("testdb: RETURNING clause of %q names %d columns, got %d values", q, len(columns), len(values)))
+	}
+
+	StubQuery(q, RowsFromSlice(columns, [][]driver.Value{values}))
+}
+
+// Returns the names of the columns in the query's top level RETURNING clause, using the alias when a column has one.
+func returningColumns(query string) []string {
+	tokens := tokenize(query)
+
+	start := -1
+	depth := 0
+	for i, t := range tokens {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && t.is("returning"):
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	var columns []string
+	name := ""
+	depth = 0
+	for _, t := range tokens[start:] {
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")":
+			depth--
+		case depth == 0 && (t.text == "," || t.text == ";"):
+			columns = append(columns, name)
+			name = ""
+		case depth == 0 && t.kind == tokenWord:
+			name = t.text
+		case depth == 0 && t.kind == tokenQuotedIdent:
+			name = strings.ReplaceAll(t.text[1:len(t.text)-1], t.text[:1]+t.text[:1], t.text[:1])
+		}
+	}
+	if name != "" {
+		columns = append(columns, name)
+	}
+
+	return columns
+}
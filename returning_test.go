@@ -0,0 +1,56 @@
+package testdb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestStubReturning(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "INSERT INTO users (name) VALUES ($1) RETURNING id, created_at AS created"
+	StubReturning(query, int64(42), "2012-10-01")
+
+	var id int64
+	var created string
+	if err := db.QueryRow(query, "tim").Scan(&id, &created); err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 42 || created != "2012-10-01" {
+		t.Fatalf("unexpected row %d %s", id, created)
+	}
+
+	res, _ := db.Query(query, "tim")
+	defer res.Close()
+	if columns, _ := res.Columns(); !reflect.DeepEqual(columns, []string{"id", "created"}) {
+		t.Fatalf("columns should come from the RETURNING clause, got %v", columns)
+	}
+}
+
+func TestReturningColumns(t *testing.T) {
+	tests := map[string][]string{
+		`update users set name = 'x' returning "Id", lower(name) as name;`:          {"Id", "name"},
+		`delete from users where id in (select id from t returning x) returning id`: {"id"},
+		`insert into users (name) values ('tim')`:                                   nil,
+	}
+
+	for query, expected := range tests {
+		if columns := returningColumns(query); !reflect.DeepEqual(columns, expected) {
+			t.Errorf("%s: expected %v, got %v", query, expected, columns)
+		}
+	}
+}
+
+func TestStubReturningMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("StubReturning should panic when the values don't match the columns")
+		}
+	}()
+
+	StubReturning("insert into users (name) values (?) returning id", 1, 2)
+}
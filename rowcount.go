@@ -0,0 +1,134 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync/atomic"
+)
+
+// Returns the number of rows the most recent db.Query() of the query has delivered so far, counted as rows.Next() reads them. A result that hasn't been fully read only counts the rows read, and a query that hasn't run returns 0.
+func LastRowCount(query string) int {
+	d.conn.mu.Lock()
+	n := d.conn.rowCounts[d.conn.hash(query)]
+	d.conn.mu.Unlock()
+
+	if n == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(n))
+}
+
+// Returns the columns of the result the most recent db.Query() of the query returned, or nil if the query hasn't run. Comparing them with the columns the code scans helps track down projection mismatches.
+func LastColumns(query string) []string {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return append([]string(nil), d.conn.lastColumns[d.conn.hash(query)]...)
+}
+
+// Wraps the rows returned for a query so the rows read from them are counted, passing the optional driver.Rows interfaces through.
+type countingRows struct {
+	driver.Rows
+	n    *int64
+	conn *conn
+	// Set for lazy rows, whose columns aren't known until they are read
+	pendingColumns string
+}
+
+func (c *conn) countRows(query string, r driver.Rows) driver.Rows {
+	n := new(int64)
+
+	hash := c.hash(query)
+
+	if _, ok := r.(*lazyRows); ok {
+		c.mu.Lock()
+		c.rowCounts[hash] = n
+		delete(c.lastColumns, hash)
+		c.mu.Unlock()
+
+		return &countingRows{Rows: r, n: n, conn: c, pendingColumns: hash}
+	}
+
+	columns := r.Columns()
+
+	c.mu.Lock()
+	c.rowCounts[hash] = n
+	c.lastColumns[hash] = columns
+	c.mu.Unlock()
+
+	return &countingRows{Rows: r, n: n, conn: c}
+}
+
+func (r *countingRows) Close() error {
+	r.conn.countClose(&r.conn.rowsCloses)
+	return r.Rows.Close()
+}
+
+func (r *countingRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+
+	if r.pendingColumns != "" {
+		columns := r.Rows.Columns()
+
+		r.conn.mu.Lock()
+		r.conn.lastColumns[r.pendingColumns] = columns
+		r.conn.mu.Unlock()
+
+		r.pendingColumns = ""
+	}
+
+	if err == nil {
+		atomic.AddInt64(r.n, 1)
+	}
+	return err
+}
+
+func (r *countingRows) ColumnTypeScanType(index int) reflect.Type {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return ct.ColumnTypeScanType(index)
+	}
+	return scanTypeAny
+}
+
+func (r *countingRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return ct.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *countingRows) ColumnTypeDatabaseTypeName(index int) string {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return ct.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *countingRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return ct.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func (r *countingRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	if ct, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return ct.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *countingRows) HasNextResultSet() bool {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *countingRows) NextResultSet() error {
+	if rs, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return rs.NextResultSet()
+	}
+	return io.EOF
+}
@@ -1,15 +1,34 @@
 package testdb
 
 import (
+	"context"
 	"database/sql/driver"
+	"errors"
+	"fmt"
 	"io"
+	"reflect"
+	"time"
 )
 
+// Returned by rows created with RowsStrict() when Next() is called again after it has returned io.EOF.
+var ErrReadAfterEOF = errors.New("testdb: rows already closed, Next called after io.EOF")
+
 type rows struct {
-	closed  bool
-	columns []string
-	rows    [][]driver.Value
-	pos     int
+	closed   bool
+	columns  []string
+	rows     [][]driver.Value
+	pos      int
+	ctx      context.Context
+	defs     []ColumnDef
+	err      error
+	closeErr error
+	interval time.Duration
+	endErr   error
+	lenient  bool
+	pad      PadMode
+	strict   bool
+	// Set once a strict result has been read past its end
+	afterEOF bool
 }
 
 func (rs *rows) clone() *rows {
@@ -17,25 +36,72 @@ func (rs *rows) clone() *rows {
 		return nil
 	}
 
-	return &rows{closed: false, columns: rs.columns, rows: rs.rows, pos: 0}
+	c := *rs
+	c.closed = false
+	c.pos = 0
+	c.afterEOF = false
+
+	return &c
 }
 
+// Copies the next row into dest. A row shorter than dest is padded with NULL, and a row wider than dest is copied up to the length of dest. Rows created with RowsStrict() return an error naming both lengths for a wide row instead.
 func (rs *rows) Next(dest []driver.Value) error {
+	if rs.ctx != nil {
+		if err := rs.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	if rs.interval > 0 && rs.pos < len(rs.rows) {
+		ctx := rs.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if err := sleepContext(ctx, rs.interval); err != nil {
+			return err
+		}
+	}
+
+	if rs.err != nil {
+		return rs.err
+	}
+
+	if rs.strict && rs.pos > len(rs.rows) {
+		rs.afterEOF = true
+		return ErrReadAfterEOF
+	}
+
 	rs.pos++
 	if rs.pos > len(rs.rows) {
 		rs.closed = true
 
+		if rs.endErr != nil {
+			return rs.endErr
+		}
 		return io.EOF // per interface spec
 	}
 
-	for i, col := range rs.rows[rs.pos-1] {
-		dest[i] = col
+	row := rs.rows[rs.pos-1]
+	if len(row) > len(dest) && rs.strict && !rs.lenient {
+		return fmt.Errorf("testdb: row %d has %d values for %d columns", rs.pos, len(row), len(dest))
+	}
+
+	// Rows with fewer values than columns are padded with NULL so nothing is left over from the previous row
+	n := copy(dest, row)
+	if rs.pad == PadWithNull {
+		clear(dest[n:])
 	}
 
 	return nil
 }
 
 func (rs *rows) Err() error {
+	if rs.afterEOF {
+		return ErrReadAfterEOF
+	}
+	if rs.pos > len(rs.rows) {
+		return rs.endErr
+	}
 	return nil
 }
 
@@ -44,5 +110,120 @@ func (rs *rows) Columns() []string {
 }
 
 func (rs *rows) Close() error {
-	return nil
+	return rs.closeErr
+}
+
+// A value that can be put in a row to make Scan() fail, it isn't one of the types database/sql knows how to convert so scanning it into anything but an interface{} or a sql.Scanner returns an error.
+var BadValue driver.Value = badValue{}
+
+type badValue struct{}
+
+func (badValue) String() string {
+	return "testdb.BadValue"
+}
+
+// Describes a column of rows created with NewTypedRows(), for code that inspects sql.ColumnType. Precision and Scale are reported by DecimalSize() when Precision is set.
+type ColumnDef struct {
+	Name       string
+	ScanType   reflect.Type
+	Nullable   bool
+	DBTypeName string
+	Precision  int64
+	Scale      int64
+}
+
+var scanTypeAny = reflect.TypeOf(new(interface{})).Elem()
+
+func (rs *rows) def(index int) (ColumnDef, bool) {
+	if index < len(rs.defs) {
+		return rs.defs[index], true
+	}
+	return ColumnDef{}, false
+}
+
+func (rs *rows) ColumnTypeScanType(index int) reflect.Type {
+	if def, ok := rs.def(index); ok && def.ScanType != nil {
+		return def.ScanType
+	}
+	return scanTypeAny
+}
+
+func (rs *rows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if def, ok := rs.def(index); ok {
+		return def.Nullable, true
+	}
+	return false, false
+}
+
+func (rs *rows) ColumnTypeDatabaseTypeName(index int) string {
+	def, _ := rs.def(index)
+	return def.DBTypeName
+}
+
+func (rs *rows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if def, _ := rs.def(index); def.Precision > 0 {
+		return def.Precision, def.Scale, true
+	}
+	return 0, 0, false
+}
+
+// Returns rows reporting the supplied columns. If every column is found by name the data is projected onto them, otherwise the columns replace the existing names by position.
+func projectRows(r driver.Rows, columns []string) (driver.Rows, error) {
+	rs, ok := r.(*rows)
+	if !ok {
+		if len(r.Columns()) != len(columns) {
+			return nil, fmt.Errorf("testdb: rows have %d columns, got %d column names", len(r.Columns()), len(columns))
+		}
+		return &renamedRows{Rows: r, columns: columns}, nil
+	}
+
+	index := make(map[string]int)
+	for i, col := range rs.columns {
+		index[col] = i
+	}
+
+	positions := make([]int, len(columns))
+	for i, col := range columns {
+		pos, ok := index[col]
+		if !ok {
+			if len(rs.columns) != len(columns) {
+				return nil, fmt.Errorf("testdb: column %q not found in rows", col)
+			}
+			p := rs.clone()
+			p.columns = append([]string(nil), columns...)
+			return p, nil
+		}
+		positions[i] = pos
+	}
+
+	p := rs.clone()
+	p.columns = append([]string(nil), columns...)
+	if rs.defs != nil {
+		p.defs = make([]ColumnDef, len(positions))
+		for i, pos := range positions {
+			p.defs[i] = rs.defs[pos]
+			p.defs[i].Name = columns[i]
+		}
+	}
+	p.rows = make([][]driver.Value, len(rs.rows))
+	for i, row := range rs.rows {
+		p.rows[i] = make([]driver.Value, len(positions))
+		for j, pos := range positions {
+			if pos < len(row) {
+				p.rows[i][j] = row[pos]
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Wraps a driver.Rows that wasn't built by this package to report different column names.
+type renamedRows struct {
+	driver.Rows
+	columns []string
+}
+
+func (r *renamedRows) Columns() []string {
+	return r.columns
 }
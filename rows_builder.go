@@ -0,0 +1,154 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+)
+
+type RowsBuilder struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// Starts building a driver.Rows with the supplied columns. Values added with AddRow are stored directly as driver.Value, so native Go types such as int, string, bool, time.Time and nil can be used without going through a CSV string.
+func NewRows(columns ...string) *RowsBuilder {
+	return &RowsBuilder{
+		columns: columns,
+		rows:    [][]driver.Value{},
+	}
+}
+
+// Appends a row to the result, panics if the number of values doesn't match the number of columns or a value can't be used as a driver.Value.
+func (b *RowsBuilder) AddRow(values ...interface{}) *RowsBuilder {
+	if len(values) != len(b.columns) {
+		panic(fmt.Sprintf("testdb: AddRow expected %d values, got %d", len(b.columns), len(values)))
+	}
+
+	row := make([]driver.Value, len(values))
+	for i, v := range values {
+		if v == BadValue {
+			row[i] = v
+			continue
+		}
+
+		val, err := driver.DefaultParameterConverter.ConvertValue(v)
+		if err != nil {
+			panic(fmt.Sprintf("testdb: AddRow column %q: %s", b.columns[i], err))
+		}
+		row[i] = val
+	}
+
+	b.rows = append(b.rows, row)
+
+	return b
+}
+
+// Appends a row where every column is NULL.
+func (b *RowsBuilder) AddNullRow() *RowsBuilder {
+	b.rows = append(b.rows, make([]driver.Value, len(b.columns)))
+
+	return b
+}
+
+// Returns the driver.Rows built so far, rows added to the builder afterwards are not included. The result can be stubbed and queried repeatedly.
+func (b *RowsBuilder) Build() driver.Rows {
+	data := make([][]driver.Value, len(b.rows))
+	copy(data, b.rows)
+
+	return RowsFromSlice(b.columns, data)
+}
+
+// Returns a driver.Rows with a row for each map, taking the values in the order of the supplied columns, or the columns RowsFromMaps() would infer when columns is nil. Keys missing from a map are NULL. Panics if a value can't be used as a driver.Value, see RowsFromMapSliceE.
+func RowsFromMapSlice(data []map[string]interface{}, columns []string) driver.Rows {
+	rows, err := RowsFromMapSliceE(data, columns)
+	if err != nil {
+		panic(err)
+	}
+	return rows
+}
+
+// Same as RowsFromMapSlice(), but returns an error for values that can't be used as a driver.Value.
+func RowsFromMapSliceE(data []map[string]interface{}, columns []string) (driver.Rows, error) {
+	if columns == nil {
+		columns = mapColumns(data, nil)
+	}
+
+	b := NewRows(columns...)
+
+	for i, m := range data {
+		row := make([]driver.Value, len(columns))
+		for j, col := range columns {
+			val, err := driver.DefaultParameterConverter.ConvertValue(m[col])
+			if err != nil {
+				return nil, fmt.Errorf("testdb: row %d column %q: %s", i, col, err)
+			}
+			row[j] = val
+		}
+		b.rows = append(b.rows, row)
+	}
+
+	return b.Build(), nil
+}
+
+// Configures the columns inferred by RowsFromMaps().
+type MapOption func(*mapOptions)
+
+type mapOptions struct {
+	order []string
+}
+
+// Puts the supplied columns first, in the order given, ahead of the remaining keys.
+func WithColumnOrder(columns ...string) MapOption {
+	return func(o *mapOptions) {
+		o.order = columns
+	}
+}
+
+// Returns a driver.Rows with a row for each map, inferring the columns from the keys. Every key used by any of the maps becomes a column, sorted alphabetically so the order is the same on every run, and keys missing from a map are NULL. Panics if a value can't be used as a driver.Value.
+func RowsFromMaps(data []map[string]interface{}, opts ...MapOption) driver.Rows {
+	var o mapOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return RowsFromMapSlice(data, mapColumns(data, o.order))
+}
+
+// Returns the ordered columns followed by the union of the keys in data, sorted.
+func mapColumns(data []map[string]interface{}, order []string) []string {
+	seen := make(map[string]bool)
+	columns := []string{}
+	for _, col := range order {
+		if !seen[col] {
+			seen[col] = true
+			columns = append(columns, col)
+		}
+	}
+
+	var rest []string
+	for _, m := range data {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				rest = append(rest, k)
+			}
+		}
+	}
+	sort.Strings(rest)
+
+	return append(columns, rest...)
+}
+
+// Returns a driver.Rows whose columns are described by defs, so sql.ColumnType reports the declared scan type, nullability and database type name for each of them.
+func NewTypedRows(defs []ColumnDef, data [][]driver.Value) driver.Rows {
+	columns := make([]string, len(defs))
+	for i, def := range defs {
+		columns[i] = def.Name
+	}
+
+	r := RowsFromSlice(columns, data).(*rows)
+	r.defs = append([]ColumnDef(nil), defs...)
+
+	return r
+}
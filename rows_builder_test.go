@@ -0,0 +1,293 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	born := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	q := "select id, name, born, active from users"
+
+	StubQuery(q, NewRows("id", "name", "born", "active").
+		AddRow(1, "tim", born, true).
+		AddNullRow().
+		Build())
+
+	for i := 0; i < 2; i++ {
+		res, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !res.Next() {
+			t.Fatal("expected first row")
+		}
+
+		var (
+			id     int64
+			name   string
+			b      time.Time
+			active bool
+		)
+		if err := res.Scan(&id, &name, &b, &active); err != nil {
+			t.Fatal(err)
+		}
+
+		if id != 1 || name != "tim" || !b.Equal(born) || !active {
+			t.Fatal("failed to return typed values")
+		}
+
+		if !res.Next() {
+			t.Fatal("expected null row")
+		}
+
+		var nullName sql.NullString
+		var nullBorn, nullID, nullActive interface{}
+		if err := res.Scan(&nullID, &nullName, &nullBorn, &nullActive); err != nil {
+			t.Fatal(err)
+		}
+
+		if nullID != nil || nullName.Valid || nullBorn != nil || nullActive != nil {
+			t.Fatal("null row should only contain nil values")
+		}
+
+		if res.Next() {
+			t.Fatal("too many rows returned")
+		}
+		res.Close()
+	}
+}
+
+func TestNewRowsArity(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("AddRow should panic when the number of values doesn't match the columns")
+		}
+	}()
+
+	NewRows("id", "name").AddRow(1)
+}
+
+func TestNewRowsBuildIsolated(t *testing.T) {
+	b := NewRows("id").AddRow(1)
+	built := b.Build()
+	b.AddRow(2)
+
+	if len(built.(*rows).rows) != 1 {
+		t.Fatal("rows added after Build should not change the built result")
+	}
+}
+
+func TestRowsFromMapSlice(t *testing.T) {
+	born := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	r := RowsFromMapSlice([]map[string]interface{}{
+		{"id": 1, "name": "tim", "born": born, "active": true},
+		{"id": 2, "name": "joe"},
+	}, []string{"name", "id", "born", "active"})
+
+	if !reflect.DeepEqual(r.Columns(), []string{"name", "id", "born", "active"}) {
+		t.Fatalf("unexpected columns %v", r.Columns())
+	}
+
+	expected := [][]driver.Value{
+		{"tim", int64(1), born, true},
+		{"joe", int64(2), nil, nil},
+	}
+
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromMapSliceE(t *testing.T) {
+	_, err := RowsFromMapSliceE([]map[string]interface{}{
+		{"id": struct{}{}},
+	}, []string{"id"})
+
+	if err == nil {
+		t.Fatal("unsupported value types should return an error")
+	}
+}
+
+func TestNewTypedRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name from users"
+	StubQuery(query, NewTypedRows([]ColumnDef{
+		{Name: "id", ScanType: reflect.TypeOf(int64(0)), Nullable: false, DBTypeName: "BIGINT"},
+		{Name: "name", ScanType: reflect.TypeOf(sql.NullString{}), Nullable: true, DBTypeName: "VARCHAR"},
+	}, [][]driver.Value{{int64(1), "tim"}}))
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if types[0].Name() != "id" || types[0].ScanType() != reflect.TypeOf(int64(0)) || types[0].DatabaseTypeName() != "BIGINT" {
+		t.Fatal("unexpected column type for id")
+	}
+
+	if nullable, ok := types[0].Nullable(); !ok || nullable {
+		t.Fatal("id should be reported as not nullable")
+	}
+
+	if types[1].ScanType() != reflect.TypeOf(sql.NullString{}) || types[1].DatabaseTypeName() != "VARCHAR" {
+		t.Fatal("unexpected column type for name")
+	}
+
+	if nullable, ok := types[1].Nullable(); !ok || !nullable {
+		t.Fatal("name should be reported as nullable")
+	}
+}
+
+func TestNewTypedRowsDecimalSize(t *testing.T) {
+	rows := AsSQLRows(t, NewTypedRows([]ColumnDef{
+		{Name: "price", ScanType: reflect.TypeOf(""), DBTypeName: "NUMERIC", Precision: 10, Scale: 2},
+		{Name: "name", ScanType: reflect.TypeOf(""), DBTypeName: "VARCHAR"},
+	}, [][]driver.Value{{"19.90", "tim"}}))
+
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if precision, scale, ok := types[0].DecimalSize(); !ok || precision != 10 || scale != 2 {
+		t.Fatalf("expected NUMERIC(10, 2), got %d %d %v", precision, scale, ok)
+	}
+
+	if _, _, ok := types[1].DecimalSize(); ok {
+		t.Fatal("columns without a precision shouldn't report a decimal size")
+	}
+}
+
+func TestUntypedRowsColumnTypes(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from users"
+	StubQuery(query, RowsFromCSVString([]string{"id"}, "1"))
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	types, _ := rows.ColumnTypes()
+	if _, ok := types[0].Nullable(); ok {
+		t.Fatal("nullability should be unknown for untyped rows")
+	}
+
+	if types[0].DatabaseTypeName() != "" {
+		t.Fatal("database type should be empty for untyped rows")
+	}
+}
+
+func TestRowsFromMaps(t *testing.T) {
+	data := []map[string]interface{}{
+		{"name": "tim", "id": 1, "age": 20},
+		{"id": 2, "email": "joe@example.com", "zip": "12345"},
+	}
+
+	expected := []string{"age", "email", "id", "name", "zip"}
+	for i := 0; i < 100; i++ {
+		if columns := RowsFromMaps(data).Columns(); !reflect.DeepEqual(columns, expected) {
+			t.Fatalf("expected %v, got %v", expected, columns)
+		}
+	}
+
+	r := RowsFromMaps(data, WithColumnOrder("id", "name"))
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name", "age", "email", "zip"}) {
+		t.Fatalf("ordered columns should come first, got %v", r.Columns())
+	}
+
+	expectedRows := [][]driver.Value{
+		{int64(1), "tim", int64(20), nil, nil},
+		{int64(2), nil, nil, "joe@example.com", "12345"},
+	}
+	if !reflect.DeepEqual(r.(*rows).rows, expectedRows) {
+		t.Fatalf("expected %v, got %v", expectedRows, r.(*rows).rows)
+	}
+
+	if columns := RowsFromMapSlice(data, nil).Columns(); !reflect.DeepEqual(columns, expected) {
+		t.Fatalf("nil columns should be inferred, got %v", columns)
+	}
+}
+
+func TestBadValue(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name from users"
+	StubQuery(query, NewRows("id", "name").AddRow(BadValue, BadValue).Build())
+
+	var id int64
+	if err := db.QueryRow(query).Scan(&id, new(string)); err == nil {
+		t.Fatal("scanning BadValue should fail")
+	}
+
+	var name string
+	if err := db.QueryRow(query).Scan(new(interface{}), &name); err == nil {
+		t.Fatal("scanning BadValue into a string should fail")
+	}
+
+	StubQuery("select id from admins", RowsFromSlice([]string{"id"}, [][]driver.Value{{BadValue}}))
+	if err := db.QueryRow("select id from admins").Scan(&id); err == nil {
+		t.Fatal("BadValue should fail in rows built from a slice too")
+	}
+}
+
+func TestRowsWithMismatchedArity(t *testing.T) {
+	data := [][]driver.Value{
+		{int64(1), "tim", "extra"},
+		{int64(2)},
+	}
+
+	r := RowsWithMismatchedArity([]string{"id", "name"}, data, PadWithNull)
+	dest := make([]driver.Value, 2)
+
+	if err := r.Next(dest); err != nil || !reflect.DeepEqual(dest, []driver.Value{int64(1), "tim"}) {
+		t.Fatalf("a wide row should be cut to the columns, got %v %v", dest, err)
+	}
+	if err := r.Next(dest); err != nil || !reflect.DeepEqual(dest, []driver.Value{int64(2), nil}) {
+		t.Fatalf("a short row should be padded with NULL, got %v %v", dest, err)
+	}
+
+	r = RowsWithMismatchedArity([]string{"id", "name"}, data, PadLeaveStale)
+	r.Next(dest)
+	if err := r.Next(dest); err != nil || !reflect.DeepEqual(dest, []driver.Value{int64(2), "tim"}) {
+		t.Fatalf("a short row should leave the previous values in place, got %v %v", dest, err)
+	}
+
+	res := AsSQLRows(t, RowsWithMismatchedArity([]string{"id", "name"}, data, PadWithNull))
+	var ids []int64
+	for res.Next() {
+		var id int64
+		var name sql.NullString
+		if err := res.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Fatalf("expected both rows to scan, got %v", ids)
+	}
+}
@@ -0,0 +1,54 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io"
+)
+
+type generatedRows struct {
+	columns []string
+	n       int
+	gen     func(i int) []driver.Value
+	fill    func(i int, dest []driver.Value)
+	pos     int
+}
+
+// Returns a driver.Rows with n rows, each produced by calling gen with the row's index as Next() reaches it. Nothing is built up front, so very large results can be simulated without holding them in memory. If gen returns the wrong number of values Next() returns an error.
+func RowsGenerated(columns []string, n int, gen func(i int) []driver.Value) driver.Rows {
+	return &generatedRows{columns: columns, n: n, gen: gen}
+}
+
+// Same as RowsGenerated(), but fill writes the values of row i straight into dest, which has one slot per column. Nothing is allocated per row by the driver, which keeps iterating over very large results cheap.
+func RowsGeneratedInto(columns []string, n int, fill func(i int, dest []driver.Value)) driver.Rows {
+	return &generatedRows{columns: columns, n: n, fill: fill}
+}
+
+func (r *generatedRows) Columns() []string {
+	return r.columns
+}
+
+func (r *generatedRows) Close() error {
+	return nil
+}
+
+func (r *generatedRows) Next(dest []driver.Value) error {
+	if r.pos >= r.n {
+		return io.EOF
+	}
+
+	if r.fill != nil {
+		r.fill(r.pos, dest)
+		r.pos++
+		return nil
+	}
+
+	row := r.gen(r.pos)
+	if len(row) != len(r.columns) {
+		return fmt.Errorf("testdb: generated row %d has %d values, expected %d", r.pos, len(row), len(r.columns))
+	}
+	r.pos++
+
+	copy(dest, row)
+	return nil
+}
@@ -0,0 +1,146 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestRowsGenerated(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from events"
+	StubQuery(query, RowsGenerated([]string{"id"}, 1000000, func(i int) []driver.Value {
+		return []driver.Value{int64(i)}
+	}))
+
+	for run := 0; run < 2; run++ {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var count, id int64
+		for rows.Next() {
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			if id != count {
+				t.Fatalf("expected id %d, got %d", count, id)
+			}
+			count++
+		}
+		rows.Close()
+
+		if count != 1000000 {
+			t.Fatalf("expected 1000000 rows, got %d", count)
+		}
+	}
+}
+
+func TestRowsGeneratedArity(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name from events"
+	StubQuery(query, RowsGenerated([]string{"id", "name"}, 2, func(i int) []driver.Value {
+		return []driver.Value{int64(i)}
+	}))
+
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		t.Fatal("a row with the wrong number of values should not be returned")
+	}
+
+	if rows.Err() == nil {
+		t.Fatal("expected an arity error")
+	}
+}
+
+func TestRowsGeneratedInto(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name from events"
+	StubQuery(query, RowsGeneratedInto([]string{"id", "name"}, 3, func(i int, dest []driver.Value) {
+		dest[0] = int64(i)
+		dest[1] = "event"
+	}))
+
+	for run := 0; run < 2; run++ {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var count int64
+		for rows.Next() {
+			var id int64
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				t.Fatal(err)
+			}
+			if id != count || name != "event" {
+				t.Fatalf("unexpected row %d %s", id, name)
+			}
+			count++
+		}
+		rows.Close()
+
+		if count != 3 {
+			t.Fatalf("expected 3 rows, got %d", count)
+		}
+	}
+}
+
+const benchmarkRows = 100000
+
+func benchmarkNext(b *testing.B, newRows func() driver.Rows) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		r := newRows()
+		dest := make([]driver.Value, len(r.Columns()))
+		for r.Next(dest) == nil {
+		}
+	}
+}
+
+func BenchmarkRowsNext(b *testing.B) {
+	data := make([][]driver.Value, benchmarkRows)
+	for i := range data {
+		data[i] = []driver.Value{"id", "name"}
+	}
+	r := RowsFromSlice([]string{"id", "name"}, data)
+
+	benchmarkNext(b, func() driver.Rows {
+		return cloneRows(r)
+	})
+}
+
+func BenchmarkRowsGenerated(b *testing.B) {
+	benchmarkNext(b, func() driver.Rows {
+		return RowsGenerated([]string{"id", "name"}, benchmarkRows, func(i int) []driver.Value {
+			return []driver.Value{"id", "name"}
+		})
+	})
+}
+
+func BenchmarkRowsGeneratedInto(b *testing.B) {
+	benchmarkNext(b, func() driver.Rows {
+		return RowsGeneratedInto([]string{"id", "name"}, benchmarkRows, func(i int, dest []driver.Value) {
+			dest[0] = "id"
+			dest[1] = "name"
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"io"
+)
+
+type lazyRows struct {
+	gen     func(args []driver.Value) ([][]driver.Value, []string, error)
+	args    []driver.Value
+	rows    *rows
+	err     error
+	started bool
+}
+
+// Stubs the global driver.Conn to build the result of db.Query() from the arguments the query was called with, but only once the result is read. gen runs on the first call to Next(), or Columns() if that comes first, so results that are never iterated are never built. An error from gen is returned by Next(), and Columns() reports no columns.
+func StubLazyQuery(q string, gen func(args []driver.Value) (data [][]driver.Value, columns []string, err error)) {
+	mustStub(d.conn.stub(q, query{
+		lazy: gen,
+	}))
+}
+
+func (r *lazyRows) load() {
+	if r.started {
+		return
+	}
+	r.started = true
+
+	data, columns, err := r.gen(r.args)
+	if err != nil {
+		r.err = err
+		return
+	}
+	r.rows = RowsFromSlice(columns, data).(*rows)
+}
+
+func (r *lazyRows) Columns() []string {
+	r.load()
+	if r.rows == nil {
+		return nil
+	}
+	return r.rows.Columns()
+}
+
+func (r *lazyRows) Close() error {
+	return nil
+}
+
+func (r *lazyRows) Next(dest []driver.Value) error {
+	r.load()
+	if r.err != nil {
+		return r.err
+	}
+	if r.rows == nil {
+		return io.EOF
+	}
+	return r.rows.Next(dest)
+}
@@ -0,0 +1,88 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStubLazyQuery(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	calls := 0
+	query := "select id from users limit ?"
+	StubLazyQuery(query, func(args []driver.Value) ([][]driver.Value, []string, error) {
+		calls++
+
+		data := make([][]driver.Value, args[0].(int64))
+		for i := range data {
+			data[i] = []driver.Value{int64(i + 1)}
+		}
+		return data, []string{"id"}, nil
+	})
+
+	rows, err := db.Query(query, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 0 {
+		t.Fatal("the generator shouldn't run before the rows are read")
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if calls != 1 || !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("expected the generator to run once for ids 1 to 3, got %d runs and %v", calls, ids)
+	}
+
+	if !reflect.DeepEqual(LastColumns(query), []string{"id"}) || LastRowCount(query) != 3 {
+		t.Fatalf("the columns and row count should be recorded once read, got %v %d", LastColumns(query), LastRowCount(query))
+	}
+
+	rows, err = db.Query(query, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if calls != 1 {
+		t.Fatal("rows that are closed without being read shouldn't run the generator")
+	}
+}
+
+func TestStubLazyQueryError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	genErr := errors.New("generator failed")
+	StubLazyQuery("select id from users", func([]driver.Value) ([][]driver.Value, []string, error) {
+		return nil, nil, genErr
+	})
+
+	rows, err := db.Query("select id from users")
+	if err != nil {
+		t.Fatalf("the query should only fail once read, got %v", err)
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		t.Fatal("expected no rows")
+	}
+	if !errors.Is(rows.Err(), genErr) {
+		t.Fatalf("expected the generator's error, got %v", rows.Err())
+	}
+}
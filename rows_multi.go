@@ -0,0 +1,60 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"io"
+)
+
+type multiRows struct {
+	sets []driver.Rows
+	cur  int
+}
+
+// Returns a driver.Rows holding several result sets, like a query running more than one statement. Next() and Columns() work on the current set, each call to rows.NextResultSet() moves on to the next set, with its own columns.
+func RowsWithResultSets(sets ...driver.Rows) driver.Rows {
+	return &multiRows{sets: sets}
+}
+
+func (m *multiRows) Columns() []string {
+	if m.cur >= len(m.sets) {
+		return nil
+	}
+	return m.sets[m.cur].Columns()
+}
+
+func (m *multiRows) Next(dest []driver.Value) error {
+	if m.cur >= len(m.sets) {
+		return io.EOF
+	}
+	return m.sets[m.cur].Next(dest)
+}
+
+func (m *multiRows) Close() error {
+	var first error
+	for _, r := range m.sets {
+		if err := r.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (m *multiRows) HasNextResultSet() bool {
+	return m.cur+1 < len(m.sets)
+}
+
+func (m *multiRows) NextResultSet() error {
+	if !m.HasNextResultSet() {
+		return io.EOF
+	}
+	m.cur++
+	return nil
+}
+
+func (m *multiRows) clone() *multiRows {
+	sets := make([]driver.Rows, len(m.sets))
+	for i, r := range m.sets {
+		sets[i] = cloneRows(r)
+	}
+	return &multiRows{sets: sets}
+}
@@ -0,0 +1,59 @@
+package testdb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestRowsWithResultSets(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name from users; select count(*) from orders"
+	StubQuery(query, RowsWithResultSets(
+		RowsFromCSVString([]string{"id", "name"}, "1,tim\n2,joe"),
+		RowsFromCSVString([]string{"count"}, "5"),
+	))
+
+	for i := 0; i < 2; i++ {
+		res, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if columns, _ := res.Columns(); !reflect.DeepEqual(columns, []string{"id", "name"}) {
+			t.Fatalf("unexpected columns in the first set %v", columns)
+		}
+
+		n := 0
+		for res.Next() {
+			n++
+		}
+		if n != 2 {
+			t.Fatalf("expected 2 rows in the first set, got %d", n)
+		}
+
+		if !res.NextResultSet() {
+			t.Fatal("expected a second result set")
+		}
+
+		if columns, _ := res.Columns(); !reflect.DeepEqual(columns, []string{"count"}) {
+			t.Fatalf("columns should change with the result set, got %v", columns)
+		}
+
+		var count int
+		if !res.Next() {
+			t.Fatal("expected a row in the second set")
+		}
+		if err := res.Scan(&count); err != nil || count != 5 {
+			t.Fatalf("expected a count of 5, got %d %v", count, err)
+		}
+
+		if res.NextResultSet() {
+			t.Fatal("there should only be two result sets")
+		}
+		res.Close()
+	}
+}
@@ -0,0 +1,163 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"testing"
+)
+
+type stepKind int
+
+const (
+	stepBegin stepKind = iota
+	stepQuery
+	stepExec
+	stepCommit
+	stepRollback
+)
+
+func (k stepKind) String() string {
+	switch k {
+	case stepBegin:
+		return "begin"
+	case stepQuery:
+		return "query"
+	case stepExec:
+		return "exec"
+	case stepCommit:
+		return "commit"
+	}
+	return "rollback"
+}
+
+type scriptStep struct {
+	kind   stepKind
+	query  string
+	rows   driver.Rows
+	result driver.Result
+	err    error
+}
+
+func (s scriptStep) String() string {
+	if s.query == "" {
+		return s.kind.String()
+	}
+	return fmt.Sprintf("%s %q", s.kind, s.query)
+}
+
+// An ordered list of the calls the code under test is expected to make, loaded with LoadScript().
+type Script struct {
+	steps []scriptStep
+}
+
+// Starts an empty script, add the expected calls with its methods in the order they should happen.
+func NewScript() *Script {
+	return &Script{}
+}
+
+// Expects db.Begin() to be called next.
+func (s *Script) Begin() *Script {
+	return s.add(scriptStep{kind: stepBegin})
+}
+
+// Expects the query to be run next with db.Query(), returning the supplied rows.
+func (s *Script) Query(q string, rows driver.Rows) *Script {
+	return s.add(scriptStep{kind: stepQuery, query: q, rows: rows})
+}
+
+// Expects the query to be run next with db.Query(), returning the supplied error.
+func (s *Script) QueryError(q string, err error) *Script {
+	return s.add(scriptStep{kind: stepQuery, query: q, err: err})
+}
+
+// Expects the query to be run next with db.Exec(), returning the supplied result.
+func (s *Script) Exec(q string, result driver.Result) *Script {
+	return s.add(scriptStep{kind: stepExec, query: q, result: result})
+}
+
+// Expects the query to be run next with db.Exec(), returning the supplied error.
+func (s *Script) ExecError(q string, err error) *Script {
+	return s.add(scriptStep{kind: stepExec, query: q, err: err})
+}
+
+// Expects the transaction to be committed next.
+func (s *Script) Commit() *Script {
+	return s.add(scriptStep{kind: stepCommit})
+}
+
+// Expects the transaction to be rolled back next.
+func (s *Script) Rollback() *Script {
+	return s.add(scriptStep{kind: stepRollback})
+}
+
+func (s *Script) add(step scriptStep) *Script {
+	s.steps = append(s.steps, step)
+	return s
+}
+
+// The progress of a loaded script, once a call deviates from it every later call fails with the same error.
+type scriptRun struct {
+	steps []scriptStep
+	pos   int
+	err   error
+}
+
+// Makes the global driver.Conn follow the script, each query, exec, begin, commit and rollback must be the next step of the script and gets that step's result. Anything else returns an error and fails every call after it, AssertScriptDone() reports it along with any steps that never ran. Queries are compared the same way stubs are matched. Reset() unloads the script.
+func LoadScript(s *Script) {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	d.conn.script = &scriptRun{steps: append([]scriptStep(nil), s.steps...)}
+}
+
+// Fails the test if the loaded script was deviated from, or has steps that haven't run yet.
+func AssertScriptDone(t testing.TB) {
+	t.Helper()
+
+	d.conn.mu.Lock()
+	run := d.conn.script
+	d.conn.mu.Unlock()
+
+	if run == nil {
+		return
+	}
+
+	if run.err != nil {
+		t.Errorf("%s", run.err)
+		return
+	}
+
+	if run.pos < len(run.steps) {
+		t.Errorf("testdb: script stopped at step %d, %s was never run", run.pos+1, run.steps[run.pos])
+	}
+}
+
+// Advances the loaded script when the call is its next step, returning the step. ok is false when there's no script.
+func (c *conn) scriptStep(kind stepKind, query string) (step scriptStep, ok bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	run := c.script
+	if run == nil {
+		return scriptStep{}, false, nil
+	}
+
+	if run.err != nil {
+		return scriptStep{}, true, run.err
+	}
+
+	got := scriptStep{kind: kind, query: query}
+	if run.pos >= len(run.steps) {
+		run.err = fmt.Errorf("testdb: script finished, got unexpected %s", got)
+		return scriptStep{}, true, run.err
+	}
+
+	step = run.steps[run.pos]
+	if step.kind != kind || (query != "" && c.hash(step.query) != c.hash(query)) {
+		run.err = fmt.Errorf("testdb: script step %d expected %s, got %s", run.pos+1, step, got)
+		return scriptStep{}, true, run.err
+	}
+
+	run.pos++
+	return step, true, nil
+}
@@ -0,0 +1,92 @@
+package testdb
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestLoadScript(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	LoadScript(NewScript().
+		Begin().
+		Query("select id from users where name = $1", RowsFromCSVString([]string{"id"}, "1")).
+		Exec("update users set active = true where id = $1", NewRowsAffectedResult(1)).
+		Commit())
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var id int64
+	if err := tx.QueryRow("SELECT id FROM users WHERE name = $1", "tim").Scan(&id); err != nil || id != 1 {
+		t.Fatalf("expected id 1, got %d %v", id, err)
+	}
+
+	res, err := tx.Exec("update users set active = true where id = $1", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatalf("expected 1 row affected, got %d", n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	AssertScriptDone(t)
+}
+
+func TestLoadScriptDeviation(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	LoadScript(NewScript().
+		Begin().
+		Exec("update users set active = true", NewRowsAffectedResult(1)).
+		Commit())
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.Exec("delete from users")
+	if err == nil || !strings.Contains(err.Error(), `step 2 expected exec "update users set active = true"`) {
+		t.Fatalf("running the wrong statement should fail, got %v", err)
+	}
+
+	if err := tx.Commit(); err == nil {
+		t.Fatal("every call after a deviation should fail")
+	}
+
+	ft := &fakeTB{}
+	AssertScriptDone(ft)
+	if !ft.failed {
+		t.Fatal("AssertScriptDone should fail after a deviation")
+	}
+}
+
+func TestAssertScriptDoneUnfinished(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	LoadScript(NewScript().Begin().Rollback())
+
+	if _, err := db.Begin(); err != nil {
+		t.Fatal(err)
+	}
+
+	ft := &fakeTB{}
+	AssertScriptDone(ft)
+	if !ft.failed {
+		t.Fatal("AssertScriptDone should fail while steps are left")
+	}
+}
@@ -0,0 +1,52 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"strings"
+)
+
+// Stores the supplied rows as the contents of table on the global driver.Conn, so "SELECT * FROM table" returns them without stubbing the query. This is not a SQL engine, only full table selects without a WHERE clause, joins, ordering or a column list are answered, anything else has to be stubbed as usual and stubs for the exact query take precedence. Table names are matched case insensitively unless quoted, seeding a table again replaces its rows.
+func Seed(table string, columns []string, data [][]driver.Value) {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	d.conn.seeds[seedKey(token{kind: tokenWord, text: table})] = RowsFromSlice(columns, data).(*rows)
+}
+
+// Returns the rows seeded for the table a full table select reads, or false if the query is anything else.
+func (c *conn) seeded(query string) (driver.Rows, bool) {
+	var words []token
+	for _, t := range tokenize(query) {
+		if t.kind != tokenSpace && t.kind != tokenComment {
+			words = append(words, t)
+		}
+	}
+
+	if len(words) > 0 && words[len(words)-1].text == ";" {
+		words = words[:len(words)-1]
+	}
+	if len(words) != 4 || !words[0].is("select") || words[1].text != "*" || !words[2].is("from") || !isIdentifier(words[3]) {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, ok := c.seeds[seedKey(words[3])]
+	if !ok {
+		return nil, false
+	}
+	return r.clone(), true
+}
+
+func (c *conn) isSeeded(query string) bool {
+	_, ok := c.seeded(query)
+	return ok
+}
+
+func seedKey(t token) string {
+	if t.kind == tokenQuotedIdent {
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], t.text[:1]+t.text[:1], t.text[:1])
+	}
+	return strings.ToLower(t.text)
+}
@@ -0,0 +1,59 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	Seed("users", []string{"id", "name"}, [][]driver.Value{
+		{int64(1), "tim"},
+		{int64(2), "joe"},
+	})
+
+	for _, query := range []string{"select * from users", "SELECT * FROM Users;", "select *\n  from \"users\" -- everyone"} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatalf("%q: %v", query, err)
+		}
+
+		var names []string
+		for rows.Next() {
+			var id int64
+			var name string
+			if err := rows.Scan(&id, &name); err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, name)
+		}
+		rows.Close()
+
+		if !reflect.DeepEqual(names, []string{"tim", "joe"}) {
+			t.Fatalf("%q: expected the seeded rows, got %v", query, names)
+		}
+	}
+
+	stmt, err := db.Prepare("select * from users")
+	if err != nil {
+		t.Fatalf("statements reading a seeded table should be prepared, got %v", err)
+	}
+	stmt.Close()
+
+	for _, query := range []string{"select name from users", "select * from users where id = 1", "select * from admins"} {
+		if _, err := db.Query(query); err == nil {
+			t.Fatalf("%q isn't a full select of a seeded table and should need a stub", query)
+		}
+	}
+
+	StubQuery("select * from users", RowsFromCSVString([]string{"id", "name"}, "3,bob"))
+	var name string
+	if err := db.QueryRow("select * from users").Scan(new(int64), &name); err != nil || name != "bob" {
+		t.Fatalf("a stub for the exact query should take precedence, got %q %v", name, err)
+	}
+}
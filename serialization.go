@@ -0,0 +1,44 @@
+package testdb
+
+import "errors"
+
+// Returned for queries stubbed with StubSerializationFailure(), like the SQLSTATE 40001 error a database reports when a transaction has to be retried.
+var ErrSerializationFailure = errors.New("testdb: could not serialize access due to concurrent update")
+
+// Makes the next call of the query fail with ErrSerializationFailure, later calls behave as stubbed. When afterCommit is set the query itself runs as stubbed and the Commit() of the transaction that follows fails instead. Either way the failure happens once, so code retrying the transaction succeeds on its second attempt.
+func StubSerializationFailure(q string, afterCommit bool) {
+	d.conn.mu.Lock()
+	d.conn.serializationFailures[d.conn.hash(q)] = afterCommit
+	d.conn.mu.Unlock()
+}
+
+// Returns ErrSerializationFailure if the query should fail now, or remembers to fail the next commit.
+func (c *conn) serializationFailure(query string) error {
+	hash := c.hash(query)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	afterCommit, ok := c.serializationFailures[hash]
+	if !ok {
+		return nil
+	}
+	delete(c.serializationFailures, hash)
+
+	if afterCommit {
+		c.failNextCommit = true
+		return nil
+	}
+	return ErrSerializationFailure
+}
+
+func (c *conn) commitFailure() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failNextCommit {
+		c.failNextCommit = false
+		return ErrSerializationFailure
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func transferWithRetry(db *sql.DB) (attempts int, err error) {
+	for attempts = 1; attempts <= 3; attempts++ {
+		err = func() error {
+			tx, err := db.Begin()
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec("update accounts set balance = balance - 10 where id = 1"); err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			return tx.Commit()
+		}()
+
+		if err != ErrSerializationFailure {
+			return attempts, err
+		}
+	}
+	return attempts, err
+}
+
+func TestStubSerializationFailure(t *testing.T) {
+	for _, afterCommit := range []bool{false, true} {
+		func() {
+			defer Reset()
+
+			db, _ := sql.Open("testdb", "")
+
+			query := "update accounts set balance = balance - 10 where id = 1"
+			StubExec(query, NewResult(0, nil, 1, nil))
+			StubSerializationFailure(query, afterCommit)
+
+			attempts, err := transferWithRetry(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if attempts != 2 {
+				t.Fatalf("afterCommit=%v: expected the transfer to succeed on the second attempt, took %d", afterCommit, attempts)
+			}
+		}()
+	}
+}
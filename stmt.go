@@ -1,15 +1,17 @@
 package testdb
 
 import (
+	"context"
 	"database/sql/driver"
 )
 
 type stmt struct {
-	queryFunc func(args []driver.Value) (driver.Rows, error)
-	execFunc  func(args []driver.Value) (driver.Result, error)
+	conn  *conn
+	query string
 }
 
 func (s *stmt) Close() error {
+	s.conn.countClose(&s.conn.stmtCloses)
 	return nil
 }
 
@@ -19,9 +21,21 @@ func (s *stmt) NumInput() int {
 }
 
 func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
-	return s.execFunc(args)
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(ctx, s.query, args, true)
 }
 
 func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
-	return s.queryFunc(args)
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	s.conn.mu.Lock()
+	s.conn.preparedQueryCount++
+	s.conn.mu.Unlock()
+
+	return s.conn.query(ctx, s.query, args, true)
 }
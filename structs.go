@@ -0,0 +1,160 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Returns the columns a struct maps to, taken from the db tags of its fields. Fields without a tag use their name in lower case, fields tagged db:"-" and unexported fields are skipped, and the fields of embedded structs are included as if they belonged to the outer struct. v can be a struct, a pointer to one, or a slice of either.
+func RowsForType(v interface{}) (columns []string, err error) {
+	t := reflect.TypeOf(v)
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice) {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("testdb: RowsForType expects a struct, got %T", v)
+	}
+
+	return structColumns(t), nil
+}
+
+func structColumns(t reflect.Type) []string {
+	columns := []string{}
+	for _, f := range structFields(t, nil) {
+		columns = append(columns, f.column)
+	}
+	return columns
+}
+
+// A column a struct maps to, with the index of its field as taken by reflect.Value.FieldByIndex().
+type structField struct {
+	column string
+	index  []int
+}
+
+func structFields(t reflect.Type, parent []int) []structField {
+	var fields []structField
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+		if tag == "-" {
+			continue
+		}
+
+		index := append(append([]int(nil), parent...), i)
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+			fields = append(fields, structFields(ft, index)...)
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if tag == "" {
+			tag = strings.ToLower(f.Name)
+		}
+		fields = append(fields, structField{column: tag, index: index})
+	}
+
+	return fields
+}
+
+// Scans every row of r into dest, which must be a pointer to a slice of structs or of pointers to structs. Columns are mapped to fields the same way as RowsForType(), and values are converted the way sql.Rows.Scan() converts them, so the result is what code scanning the rows itself would see. Returns an error if a column has no field to go in. r is closed once done.
+func ScanAll(r driver.Rows, dest interface{}) error {
+	slice := reflect.ValueOf(dest)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("testdb: ScanAll expects a pointer to a slice, got %T", dest)
+	}
+	slice = slice.Elem()
+
+	elem := slice.Type().Elem()
+	st := elem
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+	if st.Kind() != reflect.Struct {
+		return fmt.Errorf("testdb: ScanAll expects a slice of structs, got %T", dest)
+	}
+
+	byColumn := make(map[string][]int)
+	for _, f := range structFields(st, nil) {
+		byColumn[f.column] = f.index
+	}
+
+	var indexes [][]int
+	for _, col := range r.Columns() {
+		index, ok := byColumn[col]
+		if !ok {
+			r.Close()
+			return fmt.Errorf("testdb: ScanAll found no field for column %q in %s", col, st)
+		}
+		indexes = append(indexes, index)
+	}
+
+	c := newConn()
+	c.queryFunc = func(context.Context, string, []driver.Value) (driver.Rows, error) {
+		return r, nil
+	}
+
+	db := sql.OpenDB(connConnector{c})
+	defer db.Close()
+
+	rows, err := db.Query("select")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := reflect.New(st).Elem()
+
+		targets := make([]interface{}, len(indexes))
+		for i, index := range indexes {
+			f, err := fieldByIndex(v, index)
+			if err != nil {
+				return err
+			}
+			targets[i] = f.Addr().Interface()
+		}
+
+		if err := rows.Scan(targets...); err != nil {
+			return err
+		}
+
+		if elem.Kind() == reflect.Ptr {
+			v = v.Addr()
+		}
+		slice.Set(reflect.Append(slice, v))
+	}
+
+	return rows.Err()
+}
+
+// Same as reflect.Value.FieldByIndex(), but allocates the nil embedded struct pointers on the way. Pointers to unexported embedded structs can't be allocated and return an error.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("testdb: ScanAll can't set the unexported embedded %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
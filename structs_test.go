@@ -0,0 +1,102 @@
+package testdb
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type auditFields struct {
+	Created time.Time `db:"created_at"`
+	Updated time.Time `db:"updated_at"`
+}
+
+type taggedUser struct {
+	ID       int64  `db:"id"`
+	Name     string `db:"name,omitempty"`
+	Password string `db:"-"`
+	Email    string
+	secret   string
+	*auditFields
+}
+
+func TestRowsForType(t *testing.T) {
+	expected := []string{"id", "name", "email", "created_at", "updated_at"}
+
+	for _, v := range []interface{}{taggedUser{}, &taggedUser{}, []taggedUser{}, []*taggedUser{}} {
+		columns, err := RowsForType(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(columns, expected) {
+			t.Fatalf("%T: expected %v, got %v", v, expected, columns)
+		}
+	}
+
+	if _, err := RowsForType(1); err == nil {
+		t.Fatal("non struct types should return an error")
+	}
+}
+
+type Audit struct {
+	Created time.Time `db:"created_at"`
+}
+
+type Person struct {
+	ID    int64  `db:"id"`
+	Name  string `db:"name"`
+	Email sql.NullString
+	*Audit
+}
+
+func TestScanAll(t *testing.T) {
+	created := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	r := NewRows("id", "name", "email", "created_at").
+		AddRow(1, "tim", "tim@example.com", created).
+		AddRow("2", "joe", nil, created).
+		Build()
+
+	var people []Person
+	if err := ScanAll(r, &people); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Person{
+		{ID: 1, Name: "tim", Email: sql.NullString{String: "tim@example.com", Valid: true}, Audit: &Audit{Created: created}},
+		{ID: 2, Name: "joe", Audit: &Audit{Created: created}},
+	}
+	if !reflect.DeepEqual(people, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, people)
+	}
+
+	var pointers []*Person
+	if err := ScanAll(RowsFromCSVString([]string{"id", "name"}, "3,bob"), &pointers); err != nil {
+		t.Fatal(err)
+	}
+	if len(pointers) != 1 || pointers[0].ID != 3 || pointers[0].Name != "bob" {
+		t.Fatalf("unexpected people %+v", pointers)
+	}
+}
+
+func TestScanAllErrors(t *testing.T) {
+	var people []Person
+
+	if err := ScanAll(RowsFromCSVString([]string{"age"}, "20"), &people); err == nil {
+		t.Fatal("a column without a field should return an error")
+	}
+
+	if err := ScanAll(RowsFromCSVString([]string{"id"}, "abc"), &people); err == nil {
+		t.Fatal("a value that can't be converted should return an error")
+	}
+
+	var users []taggedUser
+	if err := ScanAll(RowsFromCSVString([]string{"created_at"}, "2012-10-01"), &users); err == nil {
+		t.Fatal("a column in an unexported embedded struct pointer should return an error")
+	}
+
+	if err := ScanAll(RowsFromCSVString([]string{"id"}, "1"), people); err == nil {
+		t.Fatal("a slice that isn't passed by pointer should return an error")
+	}
+}
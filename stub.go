@@ -0,0 +1,179 @@
+package testdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+)
+
+// Returned by queries that run for longer than the duration set with SetMaxQueryDuration().
+var ErrQueryTimeout = errors.New("testdb: canceling statement due to statement timeout")
+
+// Simulates a server side statement timeout, queries and exec calls stubbed with a Delay() longer than max return ErrQueryTimeout once max has passed. A context that is done first still returns its own error. Pass 0 to remove the limit.
+func SetMaxQueryDuration(max time.Duration) {
+	d.conn.mu.Lock()
+	d.conn.maxQueryDuration = max
+	d.conn.mu.Unlock()
+}
+
+// Builds a stub for a single query, started with On() and stored on the global driver.Conn by one of the Return methods.
+type Stub struct {
+	query string
+	delay time.Duration
+	times *stubTimes
+}
+
+type stubTimes struct {
+	max  int
+	used int
+}
+
+// Starts a stub for the query, which is matched the same way as StubQuery(). Nothing is stubbed until Return(), ReturnResult() or ReturnError() is called.
+func On(q string) *Stub {
+	return &Stub{query: q, times: &stubTimes{}}
+}
+
+// Waits for the supplied duration before the stubbed result or error is returned, the context's error is returned instead if it is done first. This applies to db.ExecContext() as much as db.QueryContext(), on the connection, a prepared statement or a transaction, so a write cut short by a deadline fails with the context's error and never returns its Result.
+func (s *Stub) Delay(delay time.Duration) *Stub {
+	s.delay = delay
+	return s
+}
+
+// Only uses the stub for the first n calls, later calls are handled as if the query hadn't been stubbed.
+func (s *Stub) Times(n int) *Stub {
+	s.times.max = n
+	return s
+}
+
+// Stubs the query to return the supplied driver.Rows when db.Query() is called.
+func (s *Stub) Return(rows driver.Rows) *Stub {
+	return s.store(query{rows: rows}, true, false)
+}
+
+// Stubs the query to return the supplied Result when db.Exec() is called.
+func (s *Stub) ReturnResult(r *Result) *Stub {
+	return s.store(query{result: r}, false, true)
+}
+
+// Stubs the query to return the supplied error when db.Query() or db.Exec() is called.
+func (s *Stub) ReturnError(err error) *Stub {
+	return s.store(query{err: err}, true, true)
+}
+
+// Returns the number of calls the stub has answered.
+func (s *Stub) Calls() int {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return s.times.used
+}
+
+// Stubs the query to return rows with the supplied columns when db.Query() is called, the rows themselves are added to the returned StubRows.
+func (s *Stub) ReturnRows(columns ...string) *StubRows {
+	r := RowsFromSlice(columns, nil).(*rows)
+	s.Return(r)
+
+	return &StubRows{Stub: s, builder: NewRows(columns...), rows: r}
+}
+
+// The rows of a stub started with ReturnRows(), rows added to it are returned by queries run afterwards.
+type StubRows struct {
+	*Stub
+	builder *RowsBuilder
+	rows    *rows
+}
+
+// Appends a row to the stubbed result, panics under the same conditions as RowsBuilder.AddRow().
+func (sr *StubRows) AddRow(values ...interface{}) *StubRows {
+	sr.builder.AddRow(values...)
+	return sr.update()
+}
+
+// Appends a row where every column is NULL.
+func (sr *StubRows) AddNullRow() *StubRows {
+	sr.builder.AddNullRow()
+	return sr.update()
+}
+
+func (sr *StubRows) update() *StubRows {
+	d.conn.mu.Lock()
+	sr.rows.rows = append([][]driver.Value(nil), sr.builder.rows...)
+	d.conn.mu.Unlock()
+
+	return sr
+}
+
+func (s *Stub) store(q query, forQuery, forExec bool) *Stub {
+	q.delay = s.delay
+	q.times = s.times
+
+	switch {
+	case forQuery && forExec:
+		mustStub(d.conn.stubBoth(s.query, q))
+	case forQuery:
+		mustStub(d.conn.stub(s.query, q))
+	case forExec:
+		mustStub(d.conn.stubExec(s.query, q))
+	}
+
+	return s
+}
+
+// Counts a call against the stub, returning false once it has been used as many times as it allows.
+func (c *conn) use(q query) bool {
+	if q.times == nil {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if q.times.max > 0 && q.times.used >= q.times.max {
+		return false
+	}
+	q.times.used++
+
+	return true
+}
+
+// Waits out the delay of a stub, giving up with ErrQueryTimeout if it is longer than the max query duration.
+func (c *conn) runFor(ctx context.Context, delay time.Duration) error {
+	c.mu.Lock()
+	max := c.maxQueryDuration
+	c.mu.Unlock()
+
+	if max > 0 && delay > max {
+		if err := sleepContext(ctx, max); err != nil {
+			return err
+		}
+		return ErrQueryTimeout
+	}
+
+	return sleepContext(ctx, delay)
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	if d.clock != nil {
+		select {
+		case <-d.clock.After(delay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,245 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOn(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	On("select name from users").Return(RowsFromCSVString([]string{"name"}, "tim"))
+	On("update users set name = ?").ReturnError(errors.New("update failed"))
+	On("delete from users").ReturnResult(NewResult(0, nil, 3, nil))
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "tim" {
+		t.Fatal("Return should stub the rows")
+	}
+
+	if _, err := db.Exec("update users set name = ?", "joe"); err == nil || err.Error() != "update failed" {
+		t.Fatal("ReturnError should stub the error")
+	}
+
+	res, err := db.Exec("delete from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Fatal("ReturnResult should stub the result")
+	}
+}
+
+func TestOnTimes(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	s := On("select name from users").Times(2).Return(RowsFromCSVString([]string{"name"}, "tim"))
+
+	for i := 0; i < 2; i++ {
+		var name string
+		if err := db.QueryRow("select name from users").Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Query("select name from users"); err == nil {
+		t.Fatal("the stub should stop matching once it has been used twice")
+	}
+
+	if s.Calls() != 2 {
+		t.Fatalf("expected the stub to answer 2 calls, got %d", s.Calls())
+	}
+
+	if QueryCallCount("select name from users") != 3 {
+		t.Fatal("calls beyond the limit should still be counted")
+	}
+}
+
+func TestOnDelay(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	On("select name from users").Delay(20 * time.Millisecond).Return(RowsFromCSVString([]string{"name"}, "tim"))
+
+	start := time.Now()
+	var name string
+	if err := db.QueryRow("select name from users").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("the result should be delayed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	On("select id from users").Delay(time.Second).Return(RowsFromCSVString([]string{"id"}, "1"))
+	if _, err := db.QueryContext(ctx, "select id from users"); err != context.DeadlineExceeded {
+		t.Fatalf("expected the context's error, got %v", err)
+	}
+}
+
+func TestOnDelayReturnError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	stubbed := errors.New("connection reset")
+	On("update users set active = false").Delay(50 * time.Millisecond).ReturnError(stubbed)
+
+	short, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, err := db.ExecContext(short, "update users set active = false"); err != context.DeadlineExceeded {
+		t.Fatalf("a deadline shorter than the delay should win, got %v", err)
+	}
+
+	long, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := db.ExecContext(long, "update users set active = false"); err != stubbed {
+		t.Fatalf("a deadline longer than the delay should get the stubbed error, got %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("the error should be delayed")
+	}
+}
+
+func TestOnDelayReturnResultCanceled(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "insert into users (name) values (?)"
+	On(query).Delay(time.Second).ReturnResult(NewResult(1, nil, 1, nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	res, err := db.ExecContext(ctx, query, "tim")
+	if !errors.Is(err, context.DeadlineExceeded) || res != nil {
+		t.Fatalf("expected the write to fail with the context's error, got %v %v", res, err)
+	}
+	if time.Since(start) >= time.Second {
+		t.Fatal("the exec should give up once the context is done")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+	}()
+
+	if _, err := stmt.ExecContext(canceled, "joe"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled write in a transaction to fail, got %v", err)
+	}
+	tx.Rollback()
+
+	if len(Calls()) != 2 {
+		t.Fatalf("canceled writes should still be recorded, got %v", Calls())
+	}
+}
+
+func TestOnReturnRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	s := On("select id, name from users").ReturnRows("id", "name").
+		AddRow(1, "tim").
+		AddNullRow().
+		AddRow(3, "bob")
+
+	res, err := db.Query("select id, name from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for res.Next() {
+		var id sql.NullInt64
+		var name sql.NullString
+		if err := res.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name.String)
+	}
+	res.Close()
+
+	if strings.Join(names, ",") != "tim,,bob" {
+		t.Fatalf("unexpected rows %v", names)
+	}
+
+	if s.Calls() != 1 {
+		t.Fatal("calls should be counted on the stub")
+	}
+}
+
+func TestOnReturnRowsArity(t *testing.T) {
+	defer Reset()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("AddRow should panic when the number of values doesn't match the columns")
+		}
+	}()
+
+	On("select id, name from users").ReturnRows("id", "name").AddRow(1)
+}
+
+func TestSetMaxQueryDuration(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMaxQueryDuration(10 * time.Millisecond)
+	On("select name from users").Delay(time.Second).Return(RowsFromCSVString([]string{"name"}, "tim"))
+	On("update users set name = 'tim'").Delay(time.Second).ReturnResult(NewResult(0, nil, 1, nil))
+	On("select id from users").Delay(time.Millisecond).Return(RowsFromCSVString([]string{"id"}, "1"))
+
+	start := time.Now()
+	if _, err := db.Query("select name from users"); err != ErrQueryTimeout {
+		t.Fatalf("expected ErrQueryTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("the query should time out after the max duration, took %s", elapsed)
+	}
+
+	if _, err := db.Exec("update users set name = 'tim'"); err != ErrQueryTimeout {
+		t.Fatalf("expected ErrQueryTimeout from exec, got %v", err)
+	}
+
+	if _, err := db.Query("select id from users"); err != nil {
+		t.Fatal("queries shorter than the max duration should succeed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := db.QueryContext(ctx, "select name from users"); err != context.DeadlineExceeded {
+		t.Fatalf("a shorter deadline should win, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Parses a table of | separated cells, with the column names on the first line, into a driver.Rows. Cells are trimmed and converted the same way as RowsFromCSVString(). Markdown tables work too, the outer pipes of lines starting with a pipe and the line of dashes under the header are ignored. Returns an error if there's no header or a line doesn't have one cell per column.
+func RowsFromTable(s string) (driver.Rows, error) {
+	var columns []string
+	data := [][]driver.Value{}
+
+	for n, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || isTableSeparator(line) {
+			continue
+		}
+
+		cells := tableCells(line)
+		if columns == nil {
+			columns = cells
+			continue
+		}
+
+		if len(cells) != len(columns) {
+			return nil, fmt.Errorf("testdb: table line %d has %d cells for %d columns", n+1, len(cells), len(columns))
+		}
+
+		row := make([]driver.Value, len(cells))
+		for i, cell := range cells {
+			row[i] = parseCSVValue(cell)
+		}
+		data = append(data, row)
+	}
+
+	if columns == nil {
+		return nil, errors.New("testdb: table has no header")
+	}
+
+	return RowsFromSlice(columns, data), nil
+}
+
+func tableCells(line string) []string {
+	// Only a line opening with a pipe has outer pipes, otherwise a trailing one is an empty last cell
+	if strings.HasPrefix(line, "|") {
+		line = strings.TrimSuffix(line[1:], "|")
+	}
+
+	cells := strings.Split(line, "|")
+	for i, cell := range cells {
+		cells[i] = strings.TrimSpace(cell)
+	}
+	return cells
+}
+
+// Reports whether the line only underlines the header, like "---|:---:".
+func isTableSeparator(line string) bool {
+	return strings.Trim(line, "|-:+ ") == "" && strings.Contains(line, "-")
+}
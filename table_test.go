@@ -0,0 +1,68 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRowsFromTable(t *testing.T) {
+	r, err := RowsFromTable(`
+	id | name     | born
+	1  | Big Bird | 2019-01-01
+	2  | Elmo     |
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name", "born"}) {
+		t.Fatalf("unexpected columns %v", r.Columns())
+	}
+
+	expected := [][]driver.Value{{"1", "Big Bird", "2019-01-01"}, {"2", "Elmo", ""}}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+}
+
+func TestRowsFromTableMarkdown(t *testing.T) {
+	defer EnableTimeParsing(false)
+	EnableTimeParsing(true)
+
+	r, err := RowsFromTable(`
+| id | created              |
+|----|:--------------------:|
+| 1  | 2012-10-01T01:00:01Z |
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2012, 10, 1, 1, 0, 1, 0, time.UTC)
+	if got := r.(*rows).rows; len(got) != 1 || got[0][0] != "1" || !got[0][1].(time.Time).Equal(created) {
+		t.Fatalf("unexpected rows %v", got)
+	}
+}
+
+func TestRowsFromTableEmptyBody(t *testing.T) {
+	r, err := RowsFromTable("id | name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name"}) || len(r.(*rows).rows) != 0 {
+		t.Fatal("a table with only a header should have its columns and no rows")
+	}
+}
+
+func TestRowsFromTableErrors(t *testing.T) {
+	if _, err := RowsFromTable(""); err == nil {
+		t.Fatal("a table without a header should return an error")
+	}
+
+	if _, err := RowsFromTable("id | name\n1"); err == nil {
+		t.Fatal("a line with missing cells should return an error")
+	}
+}
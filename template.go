@@ -0,0 +1,53 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Executes tmpl with data and parses the text it renders into a driver.Rows, so table driven tests can generate variations of a fixture. Text starting with a pipe is read as a table by RowsFromTable(), taking its columns from the header, which must then match columns unless columns is nil. Any other text is read as CSV with the supplied columns, the way RowsFromCSVString() reads it. Returns an error if the template can't be parsed or executed, or the rendered text doesn't have one value per column on every row.
+func RowsFromTemplate(columns []string, tmpl string, data interface{}) (driver.Rows, error) {
+	t, err := template.New("rows").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("testdb: %s", err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("testdb: %s", err)
+	}
+	text := strings.TrimSpace(b.String())
+
+	if strings.HasPrefix(text, "|") {
+		rows, err := RowsFromTable(text)
+		if err != nil {
+			return nil, err
+		}
+		if columns != nil && !slices.Equal(rows.Columns(), columns) {
+			return nil, fmt.Errorf("testdb: template rendered columns %v, expected %v", rows.Columns(), columns)
+		}
+		return rows, nil
+	}
+
+	records, err := readCSV(text, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]driver.Value, len(records))
+	for i, record := range records {
+		if len(record) != len(columns) {
+			return nil, fmt.Errorf("testdb: row %d has %d values, expected %d", i+1, len(record), len(columns))
+		}
+
+		values[i] = make([]driver.Value, len(columns))
+		for j, v := range record {
+			values[i][j] = parseCSVValue(strings.TrimSpace(v))
+		}
+	}
+
+	return RowsFromSlice(columns, values), nil
+}
@@ -0,0 +1,56 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestRowsFromTemplate(t *testing.T) {
+	users := []struct {
+		ID   int
+		Name string
+	}{{1, "tim"}, {2, "joe"}, {3, "bob"}}
+
+	r, err := RowsFromTemplate([]string{"id", "name"}, `
+{{range .}}{{.ID}},{{.Name}}
+{{end}}`, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]driver.Value{{"1", "tim"}, {"2", "joe"}, {"3", "bob"}}
+	if !reflect.DeepEqual(r.(*rows).rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, r.(*rows).rows)
+	}
+
+	r, err = RowsFromTemplate(nil, `
+| id | name |
+{{range .}}| {{.ID}} | {{.Name}} |
+{{end}}`, users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(r.Columns(), []string{"id", "name"}) || len(r.(*rows).rows) != 3 {
+		t.Fatalf("expected a table of 3 users, got %v %v", r.Columns(), r.(*rows).rows)
+	}
+}
+
+func TestRowsFromTemplateErrors(t *testing.T) {
+	if _, err := RowsFromTemplate([]string{"id"}, "{{.Missing}", nil); err == nil {
+		t.Fatal("a malformed template should return an error")
+	}
+
+	if _, err := RowsFromTemplate([]string{"id"}, "{{.ID}}", 1); err == nil {
+		t.Fatal("a failing template should return an error")
+	}
+
+	if _, err := RowsFromTemplate([]string{"id"}, "1,tim", nil); err == nil {
+		t.Fatal("rows with the wrong number of values should return an error")
+	}
+
+	if _, err := RowsFromTemplate([]string{"id"}, "| name |\n| tim |", nil); err == nil {
+		t.Fatal("a table with other columns should return an error")
+	}
+}
@@ -1,13 +1,16 @@
 package testdb
 
 import (
+	"context"
 	"crypto/sha1"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/csv"
+	"fmt"
 	"io"
-	"regexp"
 	"strings"
+	"sync/atomic"
+	"testing"
 	"time"
 )
 
@@ -15,19 +18,78 @@ var d *testDriver
 
 func init() {
 	d = newDriver()
-	sql.Register("testdb", d)
+	mustStub(Register("testdb"))
 }
 
 type testDriver struct {
 	openFunc          func(dsn string) (driver.Conn, error)
 	conn              *conn
 	enableTimeParsing bool
+	newConnPerOpen    bool
+	connFactory       func(c *DSNConn)
+	openCount         int64
+	openErr           error
+	openErrCount      int64
+	csvTypeNames      map[CSVKind]string
+	clock             Clock
 }
 
 type query struct {
-	rows   driver.Rows
-	result *Result
-	err    error
+	text      string
+	columns   []string
+	rows      driver.Rows
+	result    *Result
+	err       error
+	sequence  *querySequence
+	delay     time.Duration
+	times     *stubTimes
+	nextID    *int64
+	stateful  *statefulStub
+	requireTx *bool
+	used      *bool
+	execFn    func(args []driver.Value) (driver.Result, error)
+	lazy      func(args []driver.Value) ([][]driver.Value, []string, error)
+}
+
+// Reports whether the stub answers db.Query(), as opposed to one that only holds settings such as a delay.
+func (q query) forQuery() bool {
+	return q.rows != nil || q.err != nil || q.sequence != nil || q.stateful != nil || q.lazy != nil
+}
+
+// Reports whether the stub answers db.Exec().
+func (q query) forExec() bool {
+	return q.result != nil || q.err != nil || q.nextID != nil || q.execFn != nil
+}
+
+type statefulStub struct {
+	gen   func(callNum int) (driver.Rows, error)
+	calls int
+}
+
+type argMatcher struct {
+	match func(args []driver.Value) bool
+	rows  driver.Rows
+}
+
+type ctxMatcher struct {
+	match func(ctx context.Context) bool
+	rows  driver.Rows
+}
+
+type verbArity struct {
+	verb  string
+	nargs int
+}
+
+// A single result handed back by a query stubbed with StubQuerySequence().
+type QueryResult struct {
+	Rows driver.Rows
+	Err  error
+}
+
+type querySequence struct {
+	results []QueryResult
+	pos     int
 }
 
 func newDriver() *testDriver {
@@ -41,54 +103,389 @@ func EnableTimeParsing(flag bool) {
 }
 
 func (d *testDriver) Open(dsn string) (driver.Conn, error) {
+	atomic.AddInt64(&d.openCount, 1)
+
+	if err := d.failOpen(); err != nil {
+		return nil, err
+	}
+
 	if d.openFunc != nil {
 		conn, err := d.openFunc(dsn)
 		return conn, err
 	}
 
+	if c, ok := isolated(dsn); ok {
+		return c, nil
+	}
+
+	if d.connFactory != nil {
+		c := track(newConn())
+		d.connFactory(&DSNConn{conn: c})
+		return c, nil
+	}
+
 	if d.conn == nil {
 		d.conn = newConn()
 	}
+	d.conn.setClosed(false)
+
+	if d.newConnPerOpen {
+		return &sessionConn{conn: d.conn}, nil
+	}
 
 	return d.conn, nil
 }
 
-var whitespaceRegexp = regexp.MustCompile("\\s")
+// When set to true, every db.Open() hands back a new connection sharing the stubs of the global driver.Conn, instead of the global driver.Conn itself. database/sql discards a connection that returns driver.ErrBadConn and retries on another one, and this lets each of those connections be closed independently.
+func SetNewConnPerOpen(flag bool) {
+	d.newConnPerOpen = flag
+}
 
-func getQueryHash(query string) string {
-	// Remove whitespace and lowercase to make stubbing less brittle
-	query = strings.ToLower(whitespaceRegexp.ReplaceAllString(query, ""))
+func getQueryHash(query string, caseSensitive bool) string {
+	// Remove whitespace and lowercase keywords and identifiers to make stubbing less brittle,
+	// string literals and quoted identifiers are left alone as their case matters
+	return hashString(compact(tokenize(query), !caseSensitive))
+}
 
+func hashString(s string) string {
 	h := sha1.New()
-	io.WriteString(h, query)
+	io.WriteString(h, s)
 
 	return string(h.Sum(nil))
 }
 
-// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own.
+// When set to true, keywords and unquoted identifiers are matched case sensitively too. String literals and quoted identifiers are always case sensitive, and whitespace outside of them is always ignored. This must be called before the queries are stubbed.
+func SetCaseSensitive(flag bool) {
+	d.conn.caseSensitive = flag
+}
+
+// Ignores the supplied trailing clauses, such as "ORDER BY", "LIMIT" or "OFFSET", when matching queries against stubs. Clauses are only stripped from the end of the query, outside of subqueries and string literals. This must be called before the queries are stubbed.
+func IgnoreClauses(clauses ...string) {
+	for _, clause := range clauses {
+		d.conn.ignoredClauses = append(d.conn.ignoredClauses, strings.Fields(strings.ToLower(clause)))
+	}
+}
+
+// Matches queries on the fingerprint returned by f instead of their normalized text, so a SQL parser such as pg_query_go can decide which queries are the same. Queries f returns an error for fall back to the normal matching. Pass nil to go back to matching on text. This must be called before the queries are stubbed.
+func SetFingerprintFunc(f func(query string) (string, error)) {
+	d.conn.fingerprint = f
+}
+
+// When set to true, numbers, string literals and placeholders are all treated as the same placeholder when matching queries, so a stub for "WHERE id = ?" also matches the "WHERE id = 5" an ORM sends with its arguments inlined. This must be called before the queries are stubbed.
+func SetMatchInlinedLiterals(flag bool) {
+	d.conn.matchInlinedLiterals = flag
+}
+
+// When set to true, schema qualifiers are dropped from the tables a query names after FROM, JOIN, INTO, UPDATE or TABLE, so "FROM public.users" matches a stub for "FROM users". Qualified column names and anything inside string literals are left alone. This must be called before the queries are stubbed.
+func StripSchemaQualifiers(flag bool) {
+	d.conn.stripSchemas = flag
+}
+
+// When set to true, an IN list made up only of placeholders, such as "IN (?, ?, ?)", is treated as "IN (?)" when matching queries, so one stub matches whatever number of values an ORM expands a slice to. Lists holding any literal or identifier are left alone. This must be called before the queries are stubbed.
+func SetCollapseInLists(flag bool) {
+	d.conn.collapseInLists = flag
+}
+
+// Replaces every query the global driver.Conn receives with the one f returns before anything else sees it, as a proxy that transforms SQL would. Stubs are matched against the rewritten query, and it's the one recorded in Calls() and the logs, so stubbing the expected output of a rewriting layer tests it indirectly. Unlike SetFingerprintFunc() this changes the query itself rather than how it's matched. Pass nil to remove the rewriter.
+func SetQueryRewriter(f func(query string) string) {
+	d.conn.rewriter = f
+}
+
+// When set to true, the numbers a query gives LIMIT, OFFSET and FETCH FIRST or NEXT are treated as placeholders when matching queries, so "LIMIT 10 OFFSET 20" and "LIMIT 10 OFFSET 40" match the same stub, as does "LIMIT ? OFFSET ?". Numbers elsewhere in the query are still matched exactly. This must be called before the queries are stubbed.
+func SetIgnorePagingValues(flag bool) {
+	d.conn.ignorePaging = flag
+}
+
+// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own. Returning nil rows and a nil error falls through to the stubbed queries.
 func SetQueryFunc(f func(query string) (result driver.Rows, err error)) {
 	SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
 		return f(query)
 	})
 }
 
-// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own.
+// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own. Returning nil rows and a nil error falls through to the stubbed queries.
 func SetQueryWithArgsFunc(f func(query string, args []driver.Value) (result driver.Rows, err error)) {
+	SetQueryContextFunc(func(_ context.Context, query string, args []driver.Value) (driver.Rows, error) {
+		return f(query, args)
+	})
+}
+
+// Same as SetQueryWithArgsFunc(), but f is also handed the context the query was run with, so it can look at values a request put there.
+func SetQueryContextFunc(f func(ctx context.Context, query string, args []driver.Value) (result driver.Rows, err error)) {
 	d.conn.queryFunc = f
 }
 
-// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored.
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored. String literals and quoted identifiers are matched exactly.
 func StubQuery(q string, rows driver.Rows) {
-	d.conn.queries[getQueryHash(q)] = query{
+	mustStub(StubQueryE(q, rows))
+}
+
+// Same as StubQuery(), but returns an error instead of panicking when duplicate stubs are forbidden and the query has already been stubbed.
+func StubQueryE(q string, rows driver.Rows) error {
+	return d.conn.stub(q, query{
 		rows: rows,
+	})
+}
+
+// Stubs the query to return the supplied driver.Rows while fn runs, then puts back whatever the query was stubbed with before, or removes the stub if it wasn't.
+func WithStub(q string, rows driver.Rows, fn func()) {
+	hash := d.conn.hash(q)
+	prev, stubbed := d.conn.queries[hash]
+
+	defer func() {
+		if stubbed {
+			d.conn.queries[hash] = prev
+		} else {
+			delete(d.conn.queries, hash)
+		}
+	}()
+
+	delete(d.conn.queries, hash)
+	StubQuery(q, rows)
+	fn()
+}
+
+// Returns the rows and error the query is stubbed with for db.Query(), matched the same way as when it's run, and whether it's stubbed at all. The rows are a fresh copy, so reading them doesn't affect the stub.
+func GetStub(q string) (rows driver.Rows, err error, ok bool) {
+	stub, ok := d.conn.queries[d.conn.hash(q)]
+	if !ok {
+		return nil, nil, false
 	}
+	return cloneRows(stub.rows), stub.err, true
 }
 
-// Stubs the global driver.Conn to return the supplied error when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored.
+// A read-only description of how a query is stubbed, returned by GetStubInfo(). Query is the text the stub was registered with. RowCount is -1 when the rows can't be counted without reading them, such as NDJSON fixtures. MaxCalls is 0 for stubs that answer any number of calls.
+type StubInfo struct {
+	Query    string
+	Columns  []string
+	RowCount int
+	HasError bool
+	Err      error
+	Delay    time.Duration
+	MaxCalls int
+}
+
+// Describes the stub the query is matched to, the one for db.Query() if there is one or else the one for db.Exec(), and whether it's stubbed at all. Nothing is read from the stubbed rows.
+func GetStubInfo(q string) (StubInfo, bool) {
+	hash := d.conn.hash(q)
+
+	stub, ok := d.conn.queries[hash]
+	if !ok {
+		if stub, ok = d.conn.execs[hash]; !ok {
+			return StubInfo{}, false
+		}
+	}
+
+	info := StubInfo{
+		Query:    stub.text,
+		Columns:  append([]string(nil), stub.columns...),
+		RowCount: stubRowCount(stub.rows),
+		HasError: stub.err != nil,
+		Err:      stub.err,
+		Delay:    stub.delay,
+	}
+	if stub.lazy != nil {
+		info.RowCount = -1
+	}
+	if stub.times != nil {
+		info.MaxCalls = stub.times.max
+	}
+
+	return info, true
+}
+
+func stubRowCount(r driver.Rows) int {
+	switch r := r.(type) {
+	case nil:
+		return 0
+	case *rows:
+		return len(r.rows)
+	case *generatedRows:
+		return r.n
+	}
+	return -1
+}
+
+// Stubs every query in the map to return its driver.Rows, the same as calling StubQuery() for each of them.
+func StubQueries(stubs map[string]driver.Rows) {
+	for q, rows := range stubs {
+		StubQuery(q, rows)
+	}
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows with the supplied columns when db.Query() is called. When every column is named in the rows, only those columns are returned, in the order given, so one fixture can back queries that each select a subset of it. Otherwise the columns must match the rows positionally and simply rename them.
+func StubQueryWithColumns(q string, columns []string, rows driver.Rows) {
+	projected, err := projectRows(rows, columns)
+	mustStub(err)
+
+	mustStub(d.conn.stub(q, query{
+		columns: columns,
+		rows:    projected,
+	}))
+}
+
+// Stubs the global driver.Conn to return the supplied error when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored. db.Exec() is stubbed separately with StubExecError().
 func StubQueryError(q string, err error) {
-	d.conn.queries[getQueryHash(q)] = query{
+	mustStub(d.conn.stub(q, query{
 		err: err,
+	}))
+}
+
+// Same as StubQueryError(), but the error is built with fmt.Errorf(format, args...) and its message is prefixed with the query, so a failing test shows which query returned it. Wrap a cause with %w to keep it reachable through errors.Is() and errors.As().
+func StubQueryErrorf(q string, format string, args ...interface{}) {
+	StubQueryError(q, fmt.Errorf("testdb: query %q: %w", q, fmt.Errorf(format, args...)))
+}
+
+// Stubs every query in the map to return its error, the same as calling StubQueryError() for each of them.
+func StubQueryErrors(stubs map[string]error) {
+	for q, err := range stubs {
+		StubQueryError(q, err)
+	}
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called with arguments accepted by match. Matchers are tried in the order they were stubbed, if none of them match the query falls back to any stub registered with StubQuery().
+func StubQueryWithArgMatcher(q string, match func(args []driver.Value) bool, rows driver.Rows) {
+	mustStub(checkStubQuery(q))
+
+	hash := d.conn.hash(q)
+	d.conn.argMatchers[hash] = append(d.conn.argMatchers[hash], argMatcher{match: match, rows: rows})
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.QueryContext() is called with a context accepted by match, such as one carrying a particular request id. The first matching stub for the query wins, they are tried after the argument matchers and before any stub registered with StubQuery(). Queries run without a context get context.Background().
+func StubQueryWithContext(q string, match func(ctx context.Context) bool, rows driver.Rows) {
+	mustStub(checkStubQuery(q))
+
+	hash := d.conn.hash(q)
+	d.conn.ctxMatchers[hash] = append(d.conn.ctxMatchers[hash], ctxMatcher{match: match, rows: rows})
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called with exactly the supplied arguments. The stub is found with a single map lookup on the query and arguments together, so a query can be stubbed for hundreds of argument values without slowing down, unlike StubQueryWithArgMatcher(). Arguments that don't match any stub fall back to the matchers, then to StubQuery(). Panics if an argument can't be used as a driver.Value.
+func StubQueryWithArgs(q string, rows driver.Rows, args ...interface{}) {
+	mustStub(checkStubQuery(q))
+
+	vals := make([]driver.Value, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			panic(fmt.Sprintf("testdb: StubQueryWithArgs argument %d: %s", i, err))
+		}
+		vals[i] = v
+	}
+
+	hash := d.conn.hash(q)
+	if d.conn.argStubs[hash] == nil {
+		d.conn.argStubs[hash] = make(map[string]driver.Rows)
+	}
+	d.conn.argStubs[hash][argsKey(vals)] = rows
+}
+
+// Stubs the global driver.Conn to return the supplied results one per call to db.Query(), in order. Once the sequence is exhausted the last result is repeated, unless SetSequenceErrorAfterExhaustion(true) has been called.
+func StubQuerySequence(q string, results ...QueryResult) {
+	mustStub(d.conn.stub(q, query{
+		sequence: &querySequence{results: results},
+	}))
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows and error for any query starting with verb, such as "SELECT" or "INSERT", that doesn't match a more specific stub. The verb is matched case insensitively, ignoring leading whitespace and comments. db.Exec() calls starting with the verb return the error, or a Result with no rows affected.
+func StubByVerb(verb string, rows driver.Rows, err error) {
+	d.conn.verbStubs[strings.ToLower(verb)] = query{
+		text: verb,
+		rows: rows,
+		err:  err,
+	}
+}
+
+// Same as StubByVerb(), but only for queries and exec calls starting with verb that are run with exactly nargs arguments. These are tried before the stubs registered with StubByVerb(), and after every stub for a specific query.
+func StubByVerbAndArity(verb string, nargs int, rows driver.Rows, err error) {
+	d.conn.verbArityStubs[verbArity{strings.ToLower(verb), nargs}] = query{
+		text: verb,
+		rows: rows,
+		err:  err,
+	}
+}
+
+// Stubs the global driver.Conn to call gen every time db.Query() is called, with the 1-based number of the call, and return its driver.Rows and error. Useful for results that only show up after a few calls, such as a row a poller is waiting for.
+func StubQueryStateful(q string, gen func(callNum int) (driver.Rows, error)) {
+	mustStub(d.conn.stub(q, query{
+		stateful: &statefulStub{gen: gen},
+	}))
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called inside a transaction if requireTx is set, or outside of one if it isn't. Running the query in the wrong place returns an error saying so. As every db.Open() shares the global driver.Conn, a query run while any transaction is open counts as being inside it.
+func StubQueryRequireTx(q string, requireTx bool, rows driver.Rows) {
+	mustStub(d.conn.stub(q, query{
+		rows:      rows,
+		requireTx: &requireTx,
+	}))
+}
+
+// When set to true, queries stubbed with StubQuerySequence() return an error once all of their results have been used instead of repeating the last one.
+func SetSequenceErrorAfterExhaustion(flag bool) {
+	d.conn.errorAfterSequence = flag
+}
+
+// Controls what happens when a query or exec call doesn't match any stub.
+type MissingStubBehavior int
+
+const (
+	// Unstubbed queries return a "not stubbed" error, this is the default.
+	MissingStubError MissingStubBehavior = iota
+	// Unstubbed queries return empty rows, and unstubbed exec calls a Result with zero rows affected.
+	MissingStubEmptyRows
+)
+
+// Makes every query that doesn't match a stub return the supplied driver.Rows, and every such exec call a Result with zero rows affected, for smoke tests that don't care which queries run. Stubs, query funcs and exec funcs still take precedence, so specific queries can be stubbed on top. The queries aren't recorded as unexpected. Pass nil to turn it off.
+func AlwaysReturn(rows driver.Rows) {
+	if rows == nil {
+		d.conn.always = nil
+		return
 	}
+	d.conn.always = &query{rows: rows}
+}
+
+// Same as AlwaysReturn(), but every query and exec call that doesn't match a stub returns err. Pass nil to turn it off.
+func AlwaysError(err error) {
+	if err == nil {
+		d.conn.always = nil
+		return
+	}
+	d.conn.always = &query{err: err}
+}
+
+// Sets what happens when a query or exec call doesn't match any stub. Unstubbed calls are recorded either way and can be inspected with UnexpectedQueries().
+func SetMissingStubBehavior(b MissingStubBehavior) {
+	d.conn.missingStubBehavior = b
+}
+
+// Sets the columns of the empty rows returned for the query when it isn't stubbed and SetMissingStubBehavior(MissingStubEmptyRows) is set, so code inspecting Columns() works against a known schema. The query is still recorded as unexpected.
+func SetDefaultColumns(q string, columns []string) {
+	d.conn.defaultCols[d.conn.hash(q)] = append([]string(nil), columns...)
+}
+
+// When set to true, stubbing a query that has already been stubbed panics (or returns an error from StubQueryE) rather than replacing the existing stub.
+func SetForbidDuplicateStubs(flag bool) {
+	d.conn.forbidDuplicateStubs = flag
+}
+
+func mustStub(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Stubs the global driver.Conn to return the supplied error when db.Prepare() is called for the query.
+func StubPrepareError(q string, err error) {
+	d.conn.prepareErrors[d.conn.hash(q)] = err
+}
+
+// Delays every db.Prepare() call by the supplied duration, PrepareContext() returns the context's error if it is done before the delay has passed.
+func StubPrepareDelay(delay time.Duration) {
+	d.conn.prepareDelay = delay
+}
+
+// Declares the number of arguments the query expects, calling it with a different number of arguments returns an error naming the query.
+func StubNumInput(q string, n int) {
+	d.conn.numInputs[d.conn.hash(q)] = n
 }
 
 // Set your own function to be executed when db.Open() is called. You can either hand back a valid connection, or an error. Conn() can be used to grab the global Conn object containing stubbed queries.
@@ -96,6 +493,39 @@ func SetOpenFunc(f func(dsn string) (driver.Conn, error)) {
 	d.openFunc = f
 }
 
+// Makes every db.Open() return err until Reset() is called, or only the next few when StubOpenErrorCount() is called too. database/sql opens connections lazily, so the error surfaces from the first query or db.Ping() rather than sql.Open(). Takes precedence over SetOpenFunc().
+func StubOpenError(err error) {
+	d.openErr = err
+	atomic.StoreInt64(&d.openErrCount, -1)
+}
+
+// Limits the error stubbed with StubOpenError() to the next n calls to db.Open(), after which connections open as usual. Calling it again starts a new count.
+func StubOpenErrorCount(n int) {
+	atomic.StoreInt64(&d.openErrCount, int64(n))
+}
+
+// Returns the stubbed open error while any failures are left, a negative count never runs out.
+func (d *testDriver) failOpen() error {
+	if d.openErr == nil {
+		return nil
+	}
+
+	for {
+		n := atomic.LoadInt64(&d.openErrCount)
+		if n == 0 {
+			return nil
+		}
+		if n < 0 || atomic.CompareAndSwapInt64(&d.openErrCount, n, n-1) {
+			return d.openErr
+		}
+	}
+}
+
+// When set to false, db.Exec() can't run queries on the connection directly and database/sql prepares a statement for every call instead, as it does for drivers that don't implement driver.Execer. Exec is enabled by default.
+func SetExecerEnabled(flag bool) {
+	d.conn.execerDisabled = !flag
+}
+
 // Set your own function to be executed when db.Exec is called. You can return an error or a Result object with the LastInsertId and RowsAffected
 func SetExecFunc(f func(query string) (driver.Result, error)) {
 	SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
@@ -108,16 +538,32 @@ func SetExecWithArgsFunc(f func(query string, args []driver.Value) (driver.Resul
 	d.conn.execFunc = f
 }
 
-// Stubs the global driver.Conn to return the supplied Result when db.Exec is called, query stubbing is case insensitive, and whitespace is also ignored.
+// Stubs the global driver.Conn to return the supplied Result when db.Exec is called, query stubbing is case insensitive, and whitespace is also ignored. RowsAffected() reports exactly the count the Result was built with, whatever the statement or its arguments, so a count lower than the rows a batch was meant to change simulates a partial write.
 func StubExec(q string, r *Result) {
-	d.conn.queries[getQueryHash(q)] = query{
+	mustStub(d.conn.stubExec(q, query{
 		result: r,
-	}
+	}))
+}
+
+// Stubs the global driver.Conn to return a Result with one row affected when db.Exec() is called, LastInsertId() is startID for the first call and goes up by one for every call after it.
+func StubExecAutoIncrement(q string, startID int64) {
+	mustStub(d.conn.stubExec(q, query{
+		nextID: &startID,
+	}))
 }
 
-// Stubs the global driver.Conn to return the supplied error when db.Exec() is called, query stubbing is case insensitive, and whitespace is also ignored.
+// Stubs the global driver.Conn to return the supplied error when db.Exec() is called, query stubbing is case insensitive, and whitespace is also ignored. The query can still be stubbed separately for db.Query().
 func StubExecError(q string, err error) {
-	StubQueryError(q, err)
+	mustStub(d.conn.stubExec(q, query{
+		err: err,
+	}))
+}
+
+// Stubs the global driver.Conn to call f with the bound arguments when db.Exec() is called, returning its result and error. This suits results that depend on the arguments, such as the rows affected by an UPDATE with an IN list.
+func StubExecFunc(q string, f func(args []driver.Value) (driver.Result, error)) {
+	mustStub(d.conn.stubExec(q, query{
+		execFn: f,
+	}))
 }
 
 // Set your own function to be executed when db.Begin() is called. You can either hand back a valid transaction, or an error. Conn() can be used to grab the global Conn object containing stubbed queries.
@@ -156,10 +602,95 @@ func StubRollbackError(err error) {
 	})
 }
 
+// Returns the options passed to the most recent db.BeginTx(), such as the isolation level and whether the transaction is read only.
+func LastTxOptions() driver.TxOptions {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	if len(d.conn.txOptions) == 0 {
+		return driver.TxOptions{}
+	}
+	return d.conn.txOptions[len(d.conn.txOptions)-1]
+}
+
+// Returns the options passed to every db.BeginTx() since the last Reset(), in the order the transactions were begun. db.Begin() is recorded with the zero options.
+func TxOptionsHistory() []driver.TxOptions {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return append([]driver.TxOptions(nil), d.conn.txOptions...)
+}
+
+// Fails the test unless the most recent transaction was begun with the supplied isolation level. sql.LevelDefault is what db.Begin() and db.BeginTx() without options request.
+func AssertIsolationLevel(t testing.TB, level sql.IsolationLevel) {
+	t.Helper()
+
+	history := TxOptionsHistory()
+	if len(history) == 0 {
+		t.Errorf("testdb: no transaction was begun, expected one with isolation level %s", level)
+		return
+	}
+
+	if got := sql.IsolationLevel(history[len(history)-1].Isolation); got != level {
+		t.Errorf("testdb: expected the last transaction to use isolation level %s, got %s", level, got)
+	}
+}
+
+// Reports whether a transaction begun on the global driver.Conn hasn't been committed or rolled back yet. A Commit() or Rollback() that returns an error still ends the transaction, as it does with database/sql.
+func InTransaction() bool {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.openTxs > 0
+}
+
+// Sets the error returned when the default transaction is committed or rolled back more than once, sql.ErrTxDone is returned by default.
+func SetTxDoneError(err error) {
+	d.conn.txDoneErr = err
+}
+
 // Clears all stubbed queries, and replaced functions.
 func Reset() {
-	d.conn = newConn()
+	if d.conn == nil {
+		d.conn = newConn()
+	} else {
+		d.conn.reset()
+	}
 	d.openFunc = nil
+	d.newConnPerOpen = false
+	d.connFactory = nil
+	d.openErr = nil
+	atomic.StoreInt64(&d.openErrCount, 0)
+	d.csvTypeNames = nil
+	d.clock = nil
+	ResetOpenCount()
+}
+
+// Reports whether database/sql has closed the global driver.Conn, or one of the connections sharing it handed out with SetNewConnPerOpen(true), since the driver last opened one. database/sql closes its idle connections on db.Close(), and a connection when it's discarded, so this catches code that never closes its db. As every connection from the pool shares the global driver.Conn, closing one of them is enough to report true even if others are still open.
+func IsClosed() bool {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.closed
+}
+
+// Fails the test unless IsClosed() reports true.
+func AssertClosed(t testing.TB) {
+	t.Helper()
+
+	if !IsClosed() {
+		t.Errorf("testdb: the connection was never closed")
+	}
+}
+
+// Returns the number of times database/sql has asked the driver for a new connection since the last Reset() or ResetOpenCount().
+func OpenCount() int {
+	return int(atomic.LoadInt64(&d.openCount))
+}
+
+// Sets the count returned by OpenCount() back to zero.
+func ResetOpenCount() {
+	atomic.StoreInt64(&d.openCount, 0)
 }
 
 // Returns a pointer to the global conn object associated with this driver.
@@ -167,7 +698,45 @@ func Conn() driver.Conn {
 	return d.conn
 }
 
+// Returns the number of times db.Query() went straight to the connection without preparing a statement first.
+func DirectQueryCount() int {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.directQueryCount
+}
+
+// Returns the number of times Query was called on a statement returned from Prepare().
+func PreparedQueryCount() int {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.preparedQueryCount
+}
+
 func RowsFromCSVString(columns []string, s string, c ...rune) driver.Rows {
+	return rowsFromCSV(columns, s, c, parseCSVValue)
+}
+
+func parseCSVValue(v string) driver.Value {
+	// If enableTimeParsing is on, check to see if this is a
+	// time in RFC33339 format
+	if d.enableTimeParsing {
+		if time, err := time.Parse(time.RFC3339, v); err == nil {
+			return time
+		}
+	}
+	return v
+}
+
+// Same as RowsFromCSVString(), but every value is kept as a string, even when EnableTimeParsing(true) has been called, so values that only look like times aren't converted.
+func RowsFromCSVStringRaw(columns []string, s string, c ...rune) driver.Rows {
+	return rowsFromCSV(columns, s, c, func(v string) driver.Value {
+		return v
+	})
+}
+
+func rowsFromCSV(columns []string, s string, c []rune, parse func(v string) driver.Value) driver.Rows {
 	r := strings.NewReader(strings.TrimSpace(s))
 	csvReader := csv.NewReader(r)
 	if len(c) > 0 {
@@ -185,19 +754,7 @@ func RowsFromCSVString(columns []string, s string, c ...rune) driver.Rows {
 		row := make([]driver.Value, len(columns))
 
 		for i, v := range r {
-			v := strings.TrimSpace(v)
-
-			// If enableTimeParsing is on, check to see if this is a
-			// time in RFC33339 format
-			if d.enableTimeParsing {
-				if time, err := time.Parse(time.RFC3339, v); err == nil {
-					row[i] = time
-				} else {
-					row[i] = v
-				}
-			} else {
-				row[i] = v
-			}
+			row[i] = parse(strings.TrimSpace(v))
 		}
 
 		rows = append(rows, row)
@@ -206,11 +763,79 @@ func RowsFromCSVString(columns []string, s string, c ...rune) driver.Rows {
 	return RowsFromSlice(columns, rows)
 }
 
+// Returns a driver.Rows containing the supplied data. Columns() reports the column names exactly as given, in the same order and case, which helpers such as sqlx rely on when mapping columns to struct tags. Rows don't have to be the same length, missing trailing values are returned as NULL while the values of a row past the number of columns are dropped.
 func RowsFromSlice(columns []string, data [][]driver.Value) driver.Rows {
 	return &rows{
 		closed:  false,
-		columns: columns,
+		columns: append([]string(nil), columns...),
 		rows:    data,
 		pos:     0,
 	}
 }
+
+// Returns a driver.Rows containing the supplied data, once ctx is done Next() returns ctx.Err() instead of the remaining rows.
+func RowsWithContext(ctx context.Context, columns []string, data [][]driver.Value) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.ctx = ctx
+
+	return r
+}
+
+// Returns a driver.Rows without any columns whose first call to Next() returns err, as when a connection dies before the driver has read the result's metadata.
+func RowsWithColumnsError(err error) driver.Rows {
+	r := RowsFromSlice(nil, nil).(*rows)
+	r.err = err
+
+	return r
+}
+
+// Returns a driver.Rows containing the supplied data whose Close() returns err, as when the driver fails to release the cursor.
+func RowsWithCloseError(columns []string, data [][]driver.Value, err error) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.closeErr = err
+
+	return r
+}
+
+// Returns a driver.Rows containing the supplied data where every call to Next() waits for interval before returning the next row, for code that reports progress during a long scan. Waiting stops with the context's error once the query's context is done.
+func RowsWithInterval(columns []string, data [][]driver.Value, interval time.Duration) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.interval = interval
+
+	return r
+}
+
+// How rows created with RowsWithMismatchedArity() fill the columns a short row has no value for.
+type PadMode int
+
+const (
+	// Sets the columns without a value to NULL.
+	PadWithNull PadMode = iota
+	// Leaves the columns without a value untouched, so they keep whatever the previous row put there, as a buggy driver might.
+	PadLeaveStale
+)
+
+// Returns a driver.Rows whose rows may have more or fewer values than Columns() reports, to test code that has to cope with a misbehaving driver. Next() copies as many values as fit, dropping the extra values of a wide row instead of returning an error, and fills the rest of a short row according to pad.
+func RowsWithMismatchedArity(columns []string, data [][]driver.Value, pad PadMode) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.lenient = true
+	r.pad = pad
+
+	return r
+}
+
+// Returns a driver.Rows that rejects reads past its end, as some drivers do. Next() returns io.EOF once after the last row, and every call after that returns ErrReadAfterEOF, which Err() reports from then on, so code reading past the end of a result is caught. A row with more values than the destination Next() is given returns an error instead of being cut short.
+func RowsStrict(columns []string, data [][]driver.Value) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.strict = true
+
+	return r
+}
+
+// Returns a driver.Rows that delivers all of the supplied data and then returns err, instead of io.EOF, from the next call to Next() and from Err(), as when a connection drops after most of a result has been streamed.
+func RowsWithResultAndError(columns []string, data [][]driver.Value, err error) driver.Rows {
+	r := RowsFromSlice(columns, data).(*rows)
+	r.endErr = err
+
+	return r
+}
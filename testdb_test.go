@@ -1,11 +1,16 @@
 package testdb
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSetOpenFunc(t *testing.T) {
@@ -32,6 +37,54 @@ func TestSetOpenFunc(t *testing.T) {
 	}
 }
 
+func TestStubOpenErrorCount(t *testing.T) {
+	defer Reset()
+
+	openErr := errors.New("connection refused")
+	StubOpenError(openErr)
+	StubOpenErrorCount(2)
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	var err error
+	attempts := 0
+	for attempts < 5 {
+		attempts++
+		if err = db.Ping(); !errors.Is(err, openErr) {
+			break
+		}
+	}
+
+	if err != nil || attempts != 3 {
+		t.Fatalf("expected the third attempt to connect, got %v after %d attempts", err, attempts)
+	}
+
+	if OpenCount() != 3 {
+		t.Fatalf("expected 3 calls to open, got %d", OpenCount())
+	}
+}
+
+func TestStubOpenError(t *testing.T) {
+	defer Reset()
+
+	StubOpenError(errors.New("connection refused"))
+
+	db, _ := sql.Open("testdb", "")
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := db.Ping(); err == nil {
+			t.Fatal("every open should fail without a count")
+		}
+	}
+
+	Reset()
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Reset should clear the open error, got %v", err)
+	}
+}
+
 func TestStubQuery(t *testing.T) {
 	defer Reset()
 
@@ -163,6 +216,72 @@ func TestStubQueryError(t *testing.T) {
 	}
 }
 
+func TestStubEmptyQuery(t *testing.T) {
+	defer Reset()
+
+	for _, q := range []string{"", "  \n\t", "-- nothing to see\n/* here */"} {
+		if err := StubQueryE(q, RowsFromCSVString([]string{"id"}, "1")); err == nil {
+			t.Fatalf("stubbing %q should return an error", q)
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("StubQueryError(%q) should panic", q)
+				}
+			}()
+			StubQueryError(q, errors.New("test error"))
+		}()
+
+		rows := RowsFromCSVString([]string{"id"}, "1")
+		for name, stub := range map[string]func(){
+			"StubQueryWithArgMatcher": func() {
+				StubQueryWithArgMatcher(q, func(args []driver.Value) bool { return true }, rows)
+			},
+			"StubQueryWithContext": func() {
+				StubQueryWithContext(q, func(ctx context.Context) bool { return true }, rows)
+			},
+			"StubQueryWithArgs": func() { StubQueryWithArgs(q, rows, 1) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("%s(%q) should panic", name, q)
+					}
+				}()
+				stub()
+			}()
+		}
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	SetMissingStubBehavior(MissingStubEmptyRows)
+	if _, err := db.Prepare("   "); err == nil || !strings.Contains(err.Error(), "empty query") {
+		t.Fatalf("preparing an empty query should return a descriptive error, got %v", err)
+	}
+}
+
+func TestStubQueryErrorf(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from error"
+	cause := errors.New("test error")
+
+	StubQueryErrorf(sql, "counting failed: %w", cause)
+
+	_, err := db.Query(sql)
+	if !errors.Is(err, cause) {
+		t.Fatalf("expected the error to wrap %v, got %v", cause, err)
+	}
+
+	if !strings.Contains(err.Error(), sql) || !strings.Contains(err.Error(), "counting failed: test error") {
+		t.Fatalf("expected the error to name the query and keep the message, got %q", err)
+	}
+}
+
 func TestStubQueryRowError(t *testing.T) {
 	defer Reset()
 
@@ -535,6 +654,32 @@ func TestStubExecError(t *testing.T) {
 	}
 }
 
+func TestStubExecFuncRowsAffected(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "update users set active = false where id in (?, ?, ?)"
+	StubExecFunc(query, func(args []driver.Value) (driver.Result, error) {
+		return NewRowsAffectedResult(int64(len(args))), nil
+	})
+	StubExecFunc("delete from users", func([]driver.Value) (driver.Result, error) {
+		return nil, errors.New("delete failed")
+	})
+
+	res, err := db.Exec(query, 1, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Fatalf("expected 3 rows affected, got %d", n)
+	}
+
+	if _, err := db.Exec("delete from users"); err == nil || err.Error() != "delete failed" {
+		t.Fatalf("expected the func's error, got %v", err)
+	}
+}
+
 func TestStubExecFunc(t *testing.T) {
 	defer Reset()
 
@@ -725,3 +870,1388 @@ func TestStubRollbackError(t *testing.T) {
 		t.Fatal("stubbed rollback did not return expected error")
 	}
 }
+
+func TestQueryCounts(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+
+	rows, err := db.Query(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < 2; i++ {
+		rows, err := stmt.Query()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	if DirectQueryCount() != 1 {
+		t.Fatalf("expected 1 direct query, got %d", DirectQueryCount())
+	}
+
+	if PreparedQueryCount() != 2 {
+		t.Fatalf("expected 2 prepared queries, got %d", PreparedQueryCount())
+	}
+}
+
+func TestStubQueryDuplicates(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "1"))
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "2"))
+
+	var count int64
+	if err := db.QueryRow(sql).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 {
+		t.Fatal("last stub should win by default")
+	}
+
+	SetForbidDuplicateStubs(true)
+
+	if err := StubQueryE(sql, RowsFromCSVString([]string{"count"}, "3")); err == nil {
+		t.Fatal("duplicate stub should return an error when forbidden")
+	}
+
+	if err := StubQueryE("select count(*) from bar", RowsFromCSVString([]string{"count"}, "3")); err != nil {
+		t.Fatal("new stub should not return an error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("StubQuery should panic on duplicate stubs when forbidden")
+		}
+	}()
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "3"))
+}
+
+// structScan mimics sqlx's StructScan, mapping columns to fields by their db tag.
+func structScan(rows *sql.Rows, dest interface{}) error {
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(dest).Elem()
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("db")] = i
+	}
+
+	targets := make([]interface{}, len(columns))
+	for i, col := range columns {
+		idx, ok := fields[col]
+		if !ok {
+			return errors.New("missing destination name " + col)
+		}
+		targets[i] = v.Field(idx).Addr().Interface()
+	}
+
+	return rows.Scan(targets...)
+}
+
+func TestColumnsPreserved(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select UserID, Name, createdAt from users"
+	columns := []string{"UserID", "Name", "createdAt"}
+	StubQuery(sql, RowsFromCSVString(columns, "1,tim,2012-10-01 01:00:01"))
+	columns[0] = "changed"
+
+	res, err := db.Query(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	got, _ := res.Columns()
+	if !reflect.DeepEqual(got, []string{"UserID", "Name", "createdAt"}) {
+		t.Fatalf("columns not returned verbatim: %v", got)
+	}
+
+	var u struct {
+		ID      int64  `db:"UserID"`
+		Name    string `db:"Name"`
+		Created string `db:"createdAt"`
+	}
+
+	if !res.Next() {
+		t.Fatal("expected a row")
+	}
+
+	if err := structScan(res, &u); err != nil {
+		t.Fatal(err)
+	}
+
+	if u.ID != 1 || u.Name != "tim" || u.Created != "2012-10-01 01:00:01" {
+		t.Fatal("failed to scan struct by column name")
+	}
+}
+
+func TestStubPrepareError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+	StubPrepareError(sql, errors.New("prepare failed"))
+
+	_, err := db.Prepare(sql)
+
+	if err == nil || err.Error() != "prepare failed" {
+		t.Fatal("stubbed prepare did not return expected error")
+	}
+}
+
+func TestPrepareContextCanceled(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := db.PrepareContext(ctx, sql)
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestStubPrepareDelay(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+	StubPrepareDelay(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := db.PrepareContext(ctx, sql)
+
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	StubPrepareDelay(time.Millisecond)
+
+	stmt, err := db.PrepareContext(context.Background(), sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+}
+
+func TestStubQuerySequence(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuerySequence(sql,
+		QueryResult{Rows: RowsFromCSVString([]string{"count"}, "1")},
+		QueryResult{Rows: RowsFromCSVString([]string{"count"}, "2")},
+		QueryResult{Err: errors.New("third call failed")},
+	)
+
+	for _, expected := range []int64{1, 2} {
+		var count int64
+		if err := db.QueryRow(sql).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+
+		if count != expected {
+			t.Fatalf("expected %d, got %d", expected, count)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := db.Query(sql)
+		if err == nil || err.Error() != "third call failed" {
+			t.Fatal("last result in sequence should repeat")
+		}
+	}
+}
+
+func TestStubQuerySequenceErrorAfterExhaustion(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetSequenceErrorAfterExhaustion(true)
+
+	sql := "select count(*) from foo"
+	StubQuerySequence(sql, QueryResult{Rows: RowsFromCSVString([]string{"count"}, "1")})
+
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	if err := stmt.QueryRow().Scan(&count); err != nil || count != 1 {
+		t.Fatal("first call should return the stubbed rows")
+	}
+
+	if _, err := stmt.Query(); err == nil {
+		t.Fatal("exhausted sequence should return an error")
+	}
+}
+
+type tenantKey struct{}
+
+func TestStubQueryWithContext(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users"
+	tenant := func(name string) func(ctx context.Context) bool {
+		return func(ctx context.Context) bool {
+			return ctx.Value(tenantKey{}) == name
+		}
+	}
+	StubQueryWithContext(query, tenant("acme"), RowsFromCSVString([]string{"name"}, "tim"))
+	StubQueryWithContext(query, tenant("globex"), RowsFromCSVString([]string{"name"}, "joe"))
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "bob"))
+
+	for tenant, expected := range map[string]string{"acme": "tim", "globex": "joe", "initech": "bob"} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+
+		var name string
+		if err := db.QueryRowContext(ctx, query).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Fatalf("tenant %s: expected %s, got %s", tenant, expected, name)
+		}
+	}
+}
+
+func TestSetQueryContextFunc(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	SetQueryContextFunc(func(ctx context.Context, query string, args []driver.Value) (driver.Rows, error) {
+		return RowsFromSlice([]string{"tenant"}, [][]driver.Value{{ctx.Value(tenantKey{})}}), nil
+	})
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	var tenant string
+	if err := db.QueryRowContext(ctx, "select tenant").Scan(&tenant); err != nil || tenant != "acme" {
+		t.Fatalf("expected the query func to see the context, got %q %v", tenant, err)
+	}
+}
+
+func TestStubQueryWithArgMatcher(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select name from birds where name like ?"
+	columns := []string{"name"}
+
+	StubQueryWithArgMatcher(sql, func(args []driver.Value) bool {
+		s, ok := args[0].(string)
+		return ok && strings.Contains(s, "bird")
+	}, RowsFromCSVString(columns, "big bird"))
+	StubQuery(sql, RowsFromCSVString(columns, "fallback"))
+
+	var name string
+	if err := db.QueryRow(sql, "%bird%").Scan(&name); err != nil || name != "big bird" {
+		t.Fatal("matching args should return the matcher's rows")
+	}
+
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if err := stmt.QueryRow("%bird%").Scan(&name); err != nil || name != "big bird" {
+		t.Fatal("matching args should return the matcher's rows from a prepared statement")
+	}
+
+	if err := stmt.QueryRow("%cat%").Scan(&name); err != nil || name != "fallback" {
+		t.Fatal("non matching args should fall back to the query stub")
+	}
+}
+
+func TestStubQueryWithArgs(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select name from birds where id = ?"
+	columns := []string{"name"}
+
+	StubQueryWithArgs(sql, RowsFromCSVString(columns, "big bird"), 1)
+	StubQueryWithArgs(sql, RowsFromCSVString(columns, "elmo"), "1")
+	StubQuery(sql, RowsFromCSVString(columns, "fallback"))
+
+	var name string
+	if err := db.QueryRow(sql, 1).Scan(&name); err != nil || name != "big bird" {
+		t.Fatal("matching args should return the rows stubbed for them")
+	}
+
+	if err := db.QueryRow(sql, "1").Scan(&name); err != nil || name != "elmo" {
+		t.Fatal("args of a different type should be stubbed separately")
+	}
+
+	if err := db.QueryRow(sql, 2).Scan(&name); err != nil || name != "fallback" {
+		t.Fatal("non matching args should fall back to the query stub")
+	}
+}
+
+func TestPreparedStatementArgStubs(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select name from birds where id = ?"
+	columns := []string{"name"}
+
+	StubQueryWithArgs(sql, RowsFromCSVString(columns, "big bird"), 1)
+	StubQueryWithArgMatcher(sql, func(args []driver.Value) bool {
+		return args[0] == int64(2)
+	}, RowsFromCSVString(columns, "elmo"))
+
+	stmt, err := db.Prepare(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for _, c := range []struct {
+		id   int
+		name string
+	}{{1, "big bird"}, {2, "elmo"}, {1, "big bird"}} {
+		var name string
+		if err := stmt.QueryRow(c.id).Scan(&name); err != nil || name != c.name {
+			t.Fatalf("id %d: expected %q, got %q %v", c.id, c.name, name, err)
+		}
+	}
+}
+
+const benchmarkArgVariants = 500
+
+func BenchmarkStubQueryWithArgMatcher(b *testing.B) {
+	defer Reset()
+
+	for i := 0; i < benchmarkArgVariants; i++ {
+		id := int64(i)
+		StubQueryWithArgMatcher("select name from birds where id = ?", func(args []driver.Value) bool {
+			return args[0] == id
+		}, RowsFromCSVString([]string{"name"}, "big bird"))
+	}
+
+	benchmarkArgStubs(b)
+}
+
+func BenchmarkStubQueryWithArgs(b *testing.B) {
+	defer Reset()
+
+	for i := 0; i < benchmarkArgVariants; i++ {
+		StubQueryWithArgs("select name from birds where id = ?", RowsFromCSVString([]string{"name"}, "big bird"), i)
+	}
+
+	benchmarkArgStubs(b)
+}
+
+// Queries with the arguments of the last variant stubbed, the worst case for a scan.
+func benchmarkArgStubs(b *testing.B) {
+	args := []driver.NamedValue{{Ordinal: 1, Value: int64(benchmarkArgVariants - 1)}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := d.conn.resolveQuery(context.Background(), "select name from birds where id = ?", args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRowsWithContext(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sql := "select id from users"
+	StubQuery(sql, RowsWithContext(ctx, []string{"id"}, [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}}))
+
+	rows, err := db.Query(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	i := 0
+	for rows.Next() {
+		i++
+		if i == 1 {
+			cancel()
+		}
+	}
+
+	if i != 1 {
+		t.Fatalf("iteration should stop once the context is canceled, got %d rows", i)
+	}
+
+	if rows.Err() != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", rows.Err())
+	}
+}
+
+func TestStubQueryWithColumns(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	users := RowsFromCSVString([]string{"id", "name", "age"}, "1,tim,20\n2,joe,25")
+	StubQueryWithColumns("select name, id from users", []string{"name", "id"}, users)
+	StubQueryWithColumns("select id as user_id, name as user_name, age as user_age from users", []string{"user_id", "user_name", "user_age"}, users)
+
+	rows, err := db.Query("select name, id from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns, _ := rows.Columns()
+	if !reflect.DeepEqual(columns, []string{"name", "id"}) {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		var id int64
+		if err := rows.Scan(&name, &id); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+
+	if !reflect.DeepEqual(names, []string{"tim", "joe"}) {
+		t.Fatalf("failed to project columns: %v", names)
+	}
+
+	rows, err = db.Query("select id as user_id, name as user_name, age as user_age from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns, _ = rows.Columns()
+	if !reflect.DeepEqual(columns, []string{"user_id", "user_name", "user_age"}) {
+		t.Fatalf("columns should be renamed by position: %v", columns)
+	}
+
+	if d.conn.queries[d.conn.hash("select name, id from users")].columns[0] != "name" {
+		t.Fatal("stub should record its own columns")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("unknown columns should panic")
+		}
+	}()
+	StubQueryWithColumns("select email from users", []string{"email"}, users)
+}
+
+func TestStubNumInput(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users where id = ? and age = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+	StubNumInput(query, 2)
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var name string
+	if err := stmt.QueryRow(1, 20).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stmt.Query(1); err == nil || !strings.Contains(err.Error(), "expects 2 arguments, got 1") || !strings.Contains(err.Error(), query) {
+		t.Fatalf("unexpected error for wrong number of args: %v", err)
+	}
+
+	exec := "update users set name = ? where id = ?"
+	StubExec(exec, NewResult(0, nil, 1, nil))
+	StubNumInput(exec, 2)
+
+	if _, err := db.Exec(exec, 1, 2, 3); err == nil {
+		t.Fatal("exec with the wrong number of args should fail")
+	}
+
+	if _, err := db.Exec(exec, "tim", 2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetQueryFuncFallThrough(t *testing.T) {
+	defer Reset()
+
+	var logged []string
+	SetQueryFunc(func(query string) (driver.Rows, error) {
+		logged = append(logged, query)
+		return nil, nil
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select count(*) from foo"
+	StubQuery(query, RowsFromCSVString([]string{"count"}, "5"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	if err := stmt.QueryRow().Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 5 {
+		t.Fatal("stub should supply the result when the query func returns nil")
+	}
+
+	if len(logged) != 1 || logged[0] != query {
+		t.Fatal("query func should still be called")
+	}
+
+	if _, err := db.Query("select count(*) from bar"); err == nil {
+		t.Fatal("queries missing from both the func and the stubs should fail")
+	}
+}
+
+func TestStubByVerb(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubByVerb("SELECT", RowsFromCSVString([]string{"id"}, "1"), nil)
+	StubByVerb("insert", nil, nil)
+	StubByVerb("Update", nil, errors.New("update failed"))
+	StubByVerb("DELETE", nil, errors.New("delete failed"))
+	StubQuery("select id from admins", RowsFromCSVString([]string{"id"}, "2"))
+
+	var id int64
+	if err := db.QueryRow("  /* fetch */ -- users\n\tselect id from users where name = ?", "tim").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != 1 {
+		t.Fatal("select should be answered by the verb stub")
+	}
+
+	if err := db.QueryRow("select id from admins").Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	if id != 2 {
+		t.Fatal("exact stubs should take precedence over verb stubs")
+	}
+
+	res, err := db.Exec("INSERT INTO users (name) VALUES (?)", "tim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Fatal("insert should return a result with no rows affected")
+	}
+
+	if _, err := db.Exec("update users set name = 'joe'"); err == nil || err.Error() != "update failed" {
+		t.Fatal("update should return the verb stub's error")
+	}
+
+	if _, err := db.Exec("delete from users"); err == nil || err.Error() != "delete failed" {
+		t.Fatal("delete should return the verb stub's error")
+	}
+
+	if _, err := db.Exec("truncate users"); err == nil {
+		t.Fatal("verbs that weren't stubbed should still fail")
+	}
+
+	rows, err := db.Query("insert into users (name) values (?) returning id", "tim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("a verb stubbed without rows should return an empty result")
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Query("selected from users"); err == nil {
+		t.Fatal("only whole keywords should match a verb")
+	}
+}
+
+func TestStubByVerbAndArity(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubByVerbAndArity("SELECT", 1, RowsFromCSVString([]string{"id"}, "1"), nil)
+	StubByVerbAndArity("select", 2, RowsFromCSVString([]string{"id"}, "2"), nil)
+	StubByVerb("select", RowsFromCSVString([]string{"id"}, "3"), nil)
+	StubByVerbAndArity("Update", 1, nil, errors.New("update failed"))
+	StubByVerbAndArity("delete", 1, nil, nil)
+
+	for nargs, expected := range map[int]int64{1: 1, 2: 2, 0: 3, 3: 3} {
+		args := make([]interface{}, nargs)
+		for i := range args {
+			args[i] = i
+		}
+
+		var id int64
+		if err := db.QueryRow("select id from users where id in (?, ?, ?)", args...).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		if id != expected {
+			t.Fatalf("%d args: expected %d, got %d", nargs, expected, id)
+		}
+	}
+
+	if _, err := db.Exec("UPDATE users SET name = ?", "tim"); err == nil || err.Error() != "update failed" {
+		t.Fatalf("update with one argument should return the stubbed error, got %v", err)
+	}
+
+	if _, err := db.Exec("update users set name = ? where id = ?", "tim", 1); err == nil || !strings.Contains(err.Error(), "not stubbed") {
+		t.Fatalf("update with two arguments shouldn't be stubbed, got %v", err)
+	}
+
+	stmt, err := db.Prepare("update users set name = ?")
+	if err != nil {
+		t.Fatalf("statements with a verb and arity stub should be prepared, got %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec("joe"); err == nil || err.Error() != "update failed" {
+		t.Fatalf("the prepared statement should be answered by the stub, got %v", err)
+	}
+
+	rows, err := db.Query("delete from users where id = ? returning name", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("a verb and arity stubbed without rows should return an empty result")
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRowsWithColumnsError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from users"
+	StubQuery(query, RowsWithColumnsError(driver.ErrBadConn))
+
+	res, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	if res.Next() {
+		t.Fatal("no rows should be returned")
+	}
+
+	if res.Err() != driver.ErrBadConn {
+		t.Fatalf("expected the stubbed error, got %v", res.Err())
+	}
+
+	var id int64
+	if err := db.QueryRow(query).Scan(&id); err != driver.ErrBadConn {
+		t.Fatalf("Scan should surface the stubbed error, got %v", err)
+	}
+}
+
+func TestSetExecerEnabled(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "delete from users"
+	StubExec(query, NewResult(0, nil, 1, nil))
+
+	if _, err := db.Exec(query); err != nil {
+		t.Fatal(err)
+	}
+	if PrepareCount(query) != 0 {
+		t.Fatal("exec should run directly on the connection by default")
+	}
+
+	SetExecerEnabled(false)
+
+	res, err := db.Exec(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatal("the prepared statement should return the stubbed result")
+	}
+	if PrepareCount(query) != 1 {
+		t.Fatalf("exec should prepare a statement when the execer is disabled, got %d prepares", PrepareCount(query))
+	}
+
+	SetExecerEnabled(true)
+
+	db.Exec(query)
+	if PrepareCount(query) != 1 {
+		t.Fatal("re-enabling the execer should stop preparing statements")
+	}
+}
+
+func TestStubQueries(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubQueries(map[string]driver.Rows{
+		"select name from users":    RowsFromCSVString([]string{"name"}, "tim"),
+		"select name from admins":   RowsFromCSVString([]string{"name"}, "joe"),
+		"select name from visitors": RowsFromCSVString([]string{"name"}, "bob"),
+	})
+	StubQueryErrors(map[string]error{
+		"select name from banned":  errors.New("banned failed"),
+		"select name from deleted": errors.New("deleted failed"),
+	})
+
+	for table, expected := range map[string]string{"users": "tim", "admins": "joe", "visitors": "bob"} {
+		var name string
+		if err := db.QueryRow("select name from " + table).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Fatalf("expected %s from %s, got %s", expected, table, name)
+		}
+	}
+
+	for _, table := range []string{"banned", "deleted"} {
+		if _, err := db.Query("select name from " + table); err == nil || err.Error() != table+" failed" {
+			t.Fatalf("expected the stubbed error for %s, got %v", table, err)
+		}
+	}
+}
+
+func TestRowsWithCloseError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users"
+	closeErr := errors.New("failed to close cursor")
+	StubQuery(query, RowsWithCloseError([]string{"name"}, [][]driver.Value{{"tim"}, {"joe"}}, closeErr))
+
+	firstName := func() (name string, err error) {
+		res, err := db.Query(query)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if cerr := res.Close(); err == nil {
+				err = cerr
+			}
+		}()
+
+		res.Next()
+		return name, res.Scan(&name)
+	}
+
+	name, err := firstName()
+	if name != "tim" {
+		t.Fatalf("expected tim, got %s", name)
+	}
+	if err != closeErr {
+		t.Fatalf("expected the close error, got %v", err)
+	}
+}
+
+func TestWithStub(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select name from users"
+	name := func() string {
+		var name string
+		if err := db.QueryRow(query).Scan(&name); err != nil {
+			return err.Error()
+		}
+		return name
+	}
+
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	WithStub(query, RowsFromCSVString([]string{"name"}, "joe"), func() {
+		if got := name(); got != "joe" {
+			t.Fatalf("expected the override, got %s", got)
+		}
+
+		WithStub(query, RowsFromCSVString([]string{"name"}, "bob"), func() {
+			if got := name(); got != "bob" {
+				t.Fatalf("expected the nested override, got %s", got)
+			}
+		})
+
+		if got := name(); got != "joe" {
+			t.Fatalf("the outer override should be restored, got %s", got)
+		}
+	})
+
+	if got := name(); got != "tim" {
+		t.Fatalf("the original stub should be restored, got %s", got)
+	}
+
+	WithStub("select id from users", RowsFromCSVString([]string{"id"}, "1"), func() {})
+	if _, err := db.Query("select id from users"); err == nil {
+		t.Fatal("a query that wasn't stubbed before should be unstubbed again")
+	}
+}
+
+func TestStubQueryAndExecErrorOnOneStatement(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "update users set active = true where id = ? returning name"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+	StubExecError(query, errors.New("exec failed"))
+
+	stmt, err := db.Prepare(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	var name string
+	if err := stmt.QueryRow(1).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "tim" {
+		t.Fatal("Query should return the stubbed rows")
+	}
+
+	if _, err := stmt.Exec(1); err == nil || err.Error() != "exec failed" {
+		t.Fatalf("Exec should return the stubbed error, got %v", err)
+	}
+
+	StubExec(query, NewResult(0, nil, 1, nil))
+	if err := stmt.QueryRow(1).Scan(&name); err != nil {
+		t.Fatal("stubbing Exec should leave the Query stub alone")
+	}
+}
+
+func TestRaggedRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id, name, age from users"
+	StubQuery(query, RowsFromSlice([]string{"id", "name", "age"}, [][]driver.Value{
+		{int64(1), "tim", int64(20)},
+		{int64(2), "joe"},
+		{int64(3)},
+		{int64(4), "bob", int64(40), "extra"},
+	}))
+
+	res, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	var scanned []string
+	for res.Next() {
+		var id int64
+		var name sql.NullString
+		var age sql.NullInt64
+		if err := res.Scan(&id, &name, &age); err != nil {
+			t.Fatal(err)
+		}
+		scanned = append(scanned, fmt.Sprintf("%d %v %v", id, name.Valid, age.Valid))
+	}
+
+	expected := []string{"1 true true", "2 true false", "3 false false", "4 true true"}
+	if !reflect.DeepEqual(scanned, expected) {
+		t.Fatalf("expected %v, got %v", expected, scanned)
+	}
+
+	if err := res.Err(); err != nil {
+		t.Fatalf("the extra values of a wide row should be dropped, got %v", err)
+	}
+}
+
+func TestStubExecAutoIncrement(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "insert into users (name) values (?)"
+	StubExecAutoIncrement(query, 1)
+
+	for i, name := range []string{"tim", "joe", "bob"} {
+		res, err := db.Exec(query, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id, _ := res.LastInsertId(); id != int64(i+1) {
+			t.Fatalf("expected id %d, got %d", i+1, id)
+		}
+
+		if n, _ := res.RowsAffected(); n != 1 {
+			t.Fatalf("expected one row affected, got %d", n)
+		}
+	}
+}
+
+func TestStubQueryStateful(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from jobs where status = 'done'"
+	StubQueryStateful(query, func(callNum int) (driver.Rows, error) {
+		if callNum < 4 {
+			return RowsFromCSVString([]string{"id"}, ""), nil
+		}
+		return RowsFromCSVString([]string{"id"}, "42"), nil
+	})
+
+	polls := 0
+	var id int64
+	for {
+		polls++
+		err := db.QueryRow(query).Scan(&id)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows || polls > 10 {
+			t.Fatal(err)
+		}
+	}
+
+	if polls != 4 || id != 42 {
+		t.Fatalf("expected the 4th poll to return 42, got %d on poll %d", id, polls)
+	}
+
+	if QueryCallCount(query) != 4 {
+		t.Fatal("every poll should be counted")
+	}
+}
+
+func TestRowsNextDestLength(t *testing.T) {
+	r := RowsFromSlice([]string{"id", "name"}, [][]driver.Value{{int64(1), "tim"}, {int64(2), "joe"}})
+
+	dest := make([]driver.Value, 2)
+	if err := r.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if dest[0] != int64(1) || dest[1] != "tim" {
+		t.Fatalf("unexpected row %v", dest)
+	}
+
+	dest = make([]driver.Value, 1)
+	if err := r.Next(dest); err != nil {
+		t.Fatalf("a dest shorter than the row should be filled, got %v", err)
+	}
+	if dest[0] != int64(2) {
+		t.Fatalf("expected the first value of the row, got %v", dest)
+	}
+}
+
+func TestRowsStrictNextDestLength(t *testing.T) {
+	r := RowsStrict([]string{"id", "name"}, [][]driver.Value{{int64(1), "tim"}})
+
+	err := r.Next(make([]driver.Value, 1))
+	if err == nil {
+		t.Fatal("a dest shorter than the row should return an error")
+	}
+	if err.Error() != "testdb: row 1 has 2 values for 1 columns" {
+		t.Fatalf("the error should name both lengths, got %q", err)
+	}
+}
+
+func TestRowsWithInterval(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from events"
+	interval := 10 * time.Millisecond
+	StubQuery(query, RowsWithInterval([]string{"id"}, [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}, {int64(4)}, {int64(5)}}, interval))
+
+	start := time.Now()
+	res, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ticks []time.Duration
+	for res.Next() {
+		ticks = append(ticks, time.Since(start))
+	}
+	res.Close()
+
+	if len(ticks) != 5 {
+		t.Fatalf("expected 5 rows, got %d", len(ticks))
+	}
+	if elapsed := time.Since(start); elapsed < 5*interval || elapsed > 5*interval+time.Second {
+		t.Fatalf("iterating 5 rows should take about %s, took %s", 5*interval, elapsed)
+	}
+	if ticks[0] < interval {
+		t.Fatal("the first row should be delayed too")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+
+	res, err = db.QueryContext(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for res.Next() {
+		count++
+	}
+	if count >= 5 || res.Err() == nil {
+		t.Fatalf("the scan should stop once the context is done, read %d rows", count)
+	}
+}
+
+func TestRowsWithResultAndError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "select id from events"
+	dropped := errors.New("connection reset by peer")
+	r := RowsWithResultAndError([]string{"id"}, [][]driver.Value{{int64(1)}, {int64(2)}}, dropped)
+	StubQuery(query, r)
+
+	res, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+
+	var ids []int64
+	for res.Next() {
+		var id int64
+		if err := res.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	if !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Fatalf("expected every row before the error, got %v", ids)
+	}
+	if res.Err() != dropped {
+		t.Fatalf("expected the terminal error, got %v", res.Err())
+	}
+
+	raw := cloneRows(r)
+	dest := make([]driver.Value, 1)
+	raw.Next(dest)
+	raw.Next(dest)
+	if err := raw.(*rows).Err(); err != nil {
+		t.Fatal("Err should be nil until the rows run out")
+	}
+	if err := raw.Next(dest); err != dropped {
+		t.Fatalf("Next should return the error rather than io.EOF, got %v", err)
+	}
+	if raw.(*rows).Err() != dropped {
+		t.Fatal("Err should report the terminal error")
+	}
+}
+
+func TestRowsStrict(t *testing.T) {
+	defer Reset()
+
+	r := RowsStrict([]string{"id"}, [][]driver.Value{{int64(1)}})
+	dest := make([]driver.Value, 1)
+
+	if err := r.Next(dest); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Next(dest); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last row, got %v", err)
+	}
+	if err := r.(*rows).Err(); err != nil {
+		t.Fatalf("reaching the end shouldn't be an error, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Next(dest); err != ErrReadAfterEOF {
+			t.Fatalf("expected ErrReadAfterEOF past the end, got %v", err)
+		}
+	}
+	if err := r.(*rows).Err(); err != ErrReadAfterEOF {
+		t.Fatalf("Err should report the read past the end, got %v", err)
+	}
+
+	db, _ := sql.Open("testdb", "")
+	StubQuery("select id from users", r)
+
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		var id int64
+		if err := db.QueryRow("select id from users").Scan(&id); err != nil {
+			t.Fatalf("every query should get a fresh result, got %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 1}) {
+		t.Fatalf("expected the row twice, got %v", ids)
+	}
+}
+
+func TestGetStub(t *testing.T) {
+	defer Reset()
+
+	StubQuery("select name from users", RowsFromCSVString([]string{"name"}, "tim"))
+	StubQueryError("select name from admins", errors.New("no admins"))
+
+	rows, err, ok := GetStub("SELECT name FROM users")
+	if !ok || err != nil {
+		t.Fatal("the stubbed query should be found")
+	}
+	if _, data, _ := DumpRows(rows); !reflect.DeepEqual(data, [][]driver.Value{{"tim"}}) {
+		t.Fatalf("unexpected stubbed rows %v", data)
+	}
+
+	if _, err, ok := GetStub("select name from admins"); !ok || err == nil || err.Error() != "no admins" {
+		t.Fatal("the stubbed error should be returned")
+	}
+
+	if _, _, ok := GetStub("select name from visitors"); ok {
+		t.Fatal("unstubbed queries should not be found")
+	}
+}
+
+func TestGetStubInfo(t *testing.T) {
+	defer Reset()
+
+	On("select id, name from users").Delay(time.Millisecond).Times(2).Return(RowsFromCSVString([]string{"id", "name"}, "1,tim\n2,joe"))
+	StubQueryError("select name from admins", errors.New("no admins"))
+	StubExec("delete from users", NewResult(0, nil, 2, nil))
+
+	info, ok := GetStubInfo("SELECT id, name FROM users")
+	expected := StubInfo{
+		Query:    "select id, name from users",
+		Columns:  []string{"id", "name"},
+		RowCount: 2,
+		Delay:    time.Millisecond,
+		MaxCalls: 2,
+	}
+	if !ok || !reflect.DeepEqual(info, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, info)
+	}
+
+	if info, ok := GetStubInfo("select name from admins"); !ok || !info.HasError || info.Err.Error() != "no admins" {
+		t.Fatalf("the stubbed error should be reported, got %+v", info)
+	}
+
+	if info, ok := GetStubInfo("delete from users"); !ok || info.Query != "delete from users" || info.HasError {
+		t.Fatalf("exec stubs should be reported, got %+v", info)
+	}
+
+	if _, ok := GetStubInfo("select name from visitors"); ok {
+		t.Fatal("unstubbed queries should not be found")
+	}
+}
+
+func TestIsClosed(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	if IsClosed() {
+		t.Fatal("the connection shouldn't be closed while the db is open")
+	}
+
+	ft := &fakeTB{}
+	AssertClosed(ft)
+	if !ft.failed {
+		t.Fatal("AssertClosed should fail while the connection is open")
+	}
+
+	db.Close()
+
+	if !IsClosed() {
+		t.Fatal("closing the db should close the connection")
+	}
+	AssertClosed(t)
+
+	SetNewConnPerOpen(true)
+
+	db, _ = sql.Open("testdb", "")
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if IsClosed() {
+		t.Fatal("opening a connection should clear the closed flag")
+	}
+
+	db.Close()
+	AssertClosed(t)
+}
+
+func TestAlwaysReturn(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	AlwaysReturn(RowsFromCSVString([]string{"name"}, "tim"))
+	StubQuery("select name from admins", RowsFromCSVString([]string{"name"}, "joe"))
+
+	for _, query := range []string{"select name from users", "select anything from anywhere"} {
+		for i := 0; i < 2; i++ {
+			var name string
+			if err := db.QueryRow(query).Scan(&name); err != nil || name != "tim" {
+				t.Fatalf("%s should return the rows every time, got %q %v", query, name, err)
+			}
+		}
+	}
+
+	var name string
+	if err := db.QueryRow("select name from admins").Scan(&name); err != nil || name != "joe" {
+		t.Fatalf("stubs should take precedence, got %q %v", name, err)
+	}
+
+	res, err := db.Exec("delete from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Fatalf("exec calls should affect no rows, got %d", n)
+	}
+
+	stmt, err := db.Prepare("update users set name = ?")
+	if err != nil {
+		t.Fatalf("statements should be prepared, got %v", err)
+	}
+	stmt.Close()
+
+	if len(UnexpectedQueries()) != 0 {
+		t.Fatal("queries answered by AlwaysReturn shouldn't be unexpected")
+	}
+
+	AlwaysReturn(nil)
+	if _, err := db.Query("select name from users"); err == nil {
+		t.Fatal("AlwaysReturn(nil) should turn the mode off")
+	}
+}
+
+func TestAlwaysError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	errDown := errors.New("database is down")
+	AlwaysError(errDown)
+	StubExec("delete from users", NewResult(0, nil, 2, nil))
+
+	if _, err := db.Query("select name from users"); !errors.Is(err, errDown) {
+		t.Fatalf("expected the error from every query, got %v", err)
+	}
+
+	if _, err := db.Exec("update users set name = ?", "tim"); !errors.Is(err, errDown) {
+		t.Fatalf("expected the error from every exec call, got %v", err)
+	}
+
+	if _, err := db.Exec("delete from users"); err != nil {
+		t.Fatalf("stubs should take precedence, got %v", err)
+	}
+}
+
+func TestSetQueryRewriter(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	// Routes every table to the tenant's schema
+	SetQueryRewriter(func(query string) string {
+		return strings.ReplaceAll(query, "from users", "from tenant_1.users")
+	})
+
+	StubQuery("select name from tenant_1.users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	var name string
+	if err := db.QueryRow("select name from users").Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("the rewritten query should match the stub, got %q %v", name, err)
+	}
+
+	stmt, err := db.Prepare("select name from users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	if err := stmt.QueryRow().Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("prepared statements should be rewritten too, got %q %v", name, err)
+	}
+
+	for _, call := range Calls() {
+		if call.Query != "select name from tenant_1.users" {
+			t.Fatalf("calls should record the rewritten query, got %q", call.Query)
+		}
+	}
+
+	SetQueryRewriter(nil)
+	if _, err := db.Query("select name from users"); err == nil {
+		t.Fatal("without the rewriter the original query isn't stubbed")
+	}
+}
@@ -0,0 +1,39 @@
+package testdb
+
+import "time"
+
+// The source of time used to wait out stub delays and to time calls, see SetClock().
+type Clock interface {
+	Now() time.Time
+	// Returns a channel that receives once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Replaces the real clock used to wait out delays, such as those of Delay() and RowsWithInterval(), and to time calls for LastDuration(). A fake clock that moves forward whenever After() is called makes delays instant and the recorded durations exact. Reset() puts the real clock back, pass nil to do so sooner.
+func SetClock(c Clock) {
+	d.clock = c
+}
+
+func now() time.Time {
+	if d.clock != nil {
+		return d.clock.Now()
+	}
+	return time.Now()
+}
+
+// Returns how long the most recent db.Query() or db.Exec() of the query took to return its result or error, delays included, or 0 if it hasn't run. Reading the rows returned by a query isn't included.
+func LastDuration(query string) time.Duration {
+	d.conn.mu.Lock()
+	defer d.conn.mu.Unlock()
+
+	return d.conn.durations[d.conn.hash(query)]
+}
+
+func (c *conn) recordDuration(query string, start time.Time) {
+	elapsed := now().Sub(start)
+	hash := c.hash(query)
+
+	c.mu.Lock()
+	c.durations[hash] = elapsed
+	c.mu.Unlock()
+}
@@ -0,0 +1,71 @@
+package testdb
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A clock that only moves when something waits on it.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func TestLastDuration(t *testing.T) {
+	defer Reset()
+
+	SetClock(&fakeClock{now: time.Date(2012, 10, 1, 0, 0, 0, 0, time.UTC)})
+
+	db, _ := sql.Open("testdb", "")
+
+	On("select name from users").Delay(3 * time.Second).Return(RowsFromCSVString([]string{"name"}, "tim"))
+	On("update users set name = ?").Delay(250 * time.Millisecond).ReturnResult(NewRowsAffectedResult(1))
+	StubQuery("select id from users", RowsFromCSVString([]string{"id"}, "1"))
+
+	start := time.Now()
+
+	var name string
+	if err := db.QueryRow("select name from users").Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("update users set name = ?", "joe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("select id from users").Scan(new(int64)); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatal("delays should be instant with a fake clock")
+	}
+
+	for query, expected := range map[string]time.Duration{
+		"select name from users":    3 * time.Second,
+		"update users set name = ?": 250 * time.Millisecond,
+		"select id from users":      0,
+		"select * from admins":      0,
+	} {
+		if got := LastDuration(query); got != expected {
+			t.Fatalf("%s: expected %v, got %v", query, expected, got)
+		}
+	}
+}
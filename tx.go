@@ -1,11 +1,39 @@
 package testdb
 
+import "database/sql"
+
 type Tx struct {
+	conn         *conn
 	commitFunc   func() error
 	rollbackFunc func() error
+	done         bool
+	doneErr      error
+	readOnly     bool
 }
 
 func (t *Tx) Commit() error {
+	if t.conn != nil {
+		t.conn.logf("commit")
+		t.conn.notify(func(o Observer) { o.OnCommit() })
+	}
+
+	if t.done {
+		return t.doneError()
+	}
+	t.done = true
+	if t.conn != nil {
+		t.conn.endTx(t.readOnly)
+	}
+
+	if t.conn != nil {
+		if _, ok, err := t.conn.scriptStep(stepCommit, ""); ok {
+			return err
+		}
+		if err := t.conn.commitFailure(); err != nil {
+			return err
+		}
+	}
+
 	if t.commitFunc != nil {
 		return t.commitFunc()
 	}
@@ -13,6 +41,22 @@ func (t *Tx) Commit() error {
 }
 
 func (t *Tx) Rollback() error {
+	if t.conn != nil {
+		t.conn.logf("rollback")
+		t.conn.notify(func(o Observer) { o.OnRollback() })
+	}
+
+	if t.done {
+		return t.doneError()
+	}
+	t.done = true
+	if t.conn != nil {
+		t.conn.endTx(t.readOnly)
+		if _, ok, err := t.conn.scriptStep(stepRollback, ""); ok {
+			return err
+		}
+	}
+
 	if t.rollbackFunc != nil {
 		return t.rollbackFunc()
 	}
@@ -38,3 +82,15 @@ func (t *Tx) StubRollbackError(err error) {
 		return err
 	})
 }
+
+// Sets the error returned when Commit() or Rollback() is called after the transaction has already been committed or rolled back, sql.ErrTxDone is returned by default.
+func (t *Tx) SetDoneError(err error) {
+	t.doneErr = err
+}
+
+func (t *Tx) doneError() error {
+	if t.doneErr != nil {
+		return t.doneErr
+	}
+	return sql.ErrTxDone
+}
@@ -1,7 +1,11 @@
 package testdb
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -56,3 +60,185 @@ func TestTxStubRollbackError(t *testing.T) {
 		t.Fatal("stubbed rollback did not return expected error")
 	}
 }
+
+func TestTxDoubleCommit(t *testing.T) {
+	tx := &Tx{}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err != sql.ErrTxDone {
+		t.Fatalf("second commit should return sql.ErrTxDone, got %v", err)
+	}
+
+	if err := tx.Rollback(); err != sql.ErrTxDone {
+		t.Fatalf("rollback after commit should return sql.ErrTxDone, got %v", err)
+	}
+}
+
+func TestTxCommitAfterRollback(t *testing.T) {
+	tx := &Tx{}
+	tx.SetDoneError(errors.New("already finished"))
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); err == nil || err.Error() != "already finished" {
+		t.Fatal("commit after rollback should return the configured error")
+	}
+}
+
+func TestSetTxDoneError(t *testing.T) {
+	defer Reset()
+
+	SetTxDoneError(errors.New("already finished"))
+
+	tx, _ := Conn().Begin()
+	tx.Commit()
+
+	if err := tx.Commit(); err == nil || err.Error() != "already finished" {
+		t.Fatal("double commit on the default transaction should return the configured error")
+	}
+}
+
+func TestBeginTx(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	opts := LastTxOptions()
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelSerializable || !opts.ReadOnly {
+		t.Fatalf("unexpected tx options %+v", opts)
+	}
+
+	tx, _ = db.BeginTx(context.Background(), nil)
+	tx.Rollback()
+	if LastTxOptions() != (driver.TxOptions{}) {
+		t.Fatal("options should be recorded for every transaction")
+	}
+
+	if history := TxOptionsHistory(); len(history) != 2 || sql.IsolationLevel(history[0].Isolation) != sql.LevelSerializable {
+		t.Fatalf("expected the options of both transactions, got %+v", history)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.BeginTx(ctx, nil); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAssertIsolationLevel(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	ft := &fakeTB{}
+	AssertIsolationLevel(ft, sql.LevelSerializable)
+	if !ft.failed {
+		t.Fatal("AssertIsolationLevel should fail when no transaction was begun")
+	}
+
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	AssertIsolationLevel(t, sql.LevelSerializable)
+
+	ft = &fakeTB{}
+	AssertIsolationLevel(ft, sql.LevelReadCommitted)
+	if !ft.failed || !strings.Contains(ft.msgs[0], "Read Committed") || !strings.Contains(ft.msgs[0], "Serializable") {
+		t.Fatalf("the failure should name both levels, got %v", ft.msgs)
+	}
+}
+
+func TestStubQueryRequireTx(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	transfer := "select balance from accounts where id = 1 for update"
+	report := "select sum(balance) from accounts"
+	StubQueryRequireTx(transfer, true, RowsFromCSVString([]string{"balance"}, "10"))
+	StubQueryRequireTx(report, false, RowsFromCSVString([]string{"sum"}, "100"))
+
+	var n int64
+	if err := db.QueryRow(transfer).Scan(&n); err == nil || err.Error() != "testdb: "+transfer+" must run inside a transaction" {
+		t.Fatalf("expected an error outside of a transaction, got %v", err)
+	}
+	if err := db.QueryRow(report).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ := db.Begin()
+	if err := tx.QueryRow(transfer).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.QueryRow(report).Scan(&n); err == nil || err.Error() != "testdb: "+report+" must not run inside a transaction" {
+		t.Fatalf("expected an error inside a transaction, got %v", err)
+	}
+	tx.Commit()
+
+	if err := db.QueryRow(report).Scan(&n); err != nil {
+		t.Fatal("the query should be allowed again once the transaction is committed")
+	}
+
+	tx, _ = db.Begin()
+	tx.Rollback()
+	tx.Rollback()
+	if err := db.QueryRow(transfer).Scan(&n); err == nil {
+		t.Fatal("rolling back twice should not leave a transaction open")
+	}
+}
+
+func TestStubRollbackErrorEndsTx(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	rollbackErr := errors.New("connection lost during rollback")
+	StubRollbackError(rollbackErr)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !InTransaction() {
+		t.Fatal("expected to be in a transaction after Begin")
+	}
+
+	if err := tx.Rollback(); err != rollbackErr {
+		t.Fatalf("expected the stubbed rollback error, got %v", err)
+	}
+
+	if InTransaction() {
+		t.Fatal("a failed rollback should still end the transaction")
+	}
+
+	if err := tx.Rollback(); err != sql.ErrTxDone {
+		t.Fatalf("the transaction should be done after a failed rollback, got %v", err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("a rollback error shouldn't affect commits, got %v", err)
+	}
+
+	if InTransaction() {
+		t.Fatal("a commit should end the transaction")
+	}
+}